@@ -0,0 +1,269 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+)
+
+// Alert states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert sent to the notifiers when a rule starts or stops firing
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Key       string    `json:"key"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	At        time.Time `json:"at"`
+}
+
+// Rule compare a metric to a threshold
+//
+//	Metric: name of a registered Source
+//	Operator: "<" or ">", the rule fires when Value Operator Threshold
+//	Resolve: threshold to cross back to resolve the alert (hysteresis), Threshold if not set
+//	For: seconds the condition must hold before firing
+//	Window: seconds of history the Source should consider, DefaultWindow if not set
+//	Repeat: seconds between two notifications of a firing alert, 0 to notify only once
+//	Params: Source specific parameters
+type Rule struct {
+	Name      string            `json:"name"`
+	Metric    string            `json:"metric"`
+	Operator  string            `json:"operator"`
+	Threshold float64           `json:"threshold"`
+	Resolve   *float64          `json:"resolve,omitempty"`
+	For       int               `json:"for"`
+	Window    int               `json:"window"`
+	Repeat    int               `json:"repeat"`
+	Params    map[string]string `json:"params,omitempty"`
+}
+
+// DefaultWindow seconds of history considered by the rules without Window
+const DefaultWindow = 300
+
+// Source compute the values of a metric, per key (ex: trunk)
+type Source func(window time.Duration, params map[string]string) map[string]float64
+
+// Notifier send alerts
+type Notifier interface {
+	Notify(alert *Alert) error
+}
+
+// RaiseDedupPeriod period during which an alert raised again for the same rule and key is not notified
+var RaiseDedupPeriod = 5 * time.Minute
+
+type alertState struct {
+	pendingSince time.Time
+	firing       bool
+	notifiedAt   time.Time
+}
+
+// Engine evaluate rules against sources and notify state changes
+type Engine struct {
+	mutex     *sync.Mutex
+	rules     []Rule
+	sources   map[string]Source
+	notifiers []Notifier
+	states    map[string]*alertState
+	raised    map[string]time.Time
+}
+
+// NewEngine create an Engine, rules are checked when evaluated against the registered sources
+func NewEngine(rules []Rule, notifiers []Notifier) (*Engine, error) {
+	checked := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Metric == "" {
+			return nil, errors.New("alerting rule must have a name and a metric")
+		}
+		if rule.Operator != "<" && rule.Operator != ">" {
+			return nil, fmt.Errorf("alerting rule %s: unknown operator <%s>", rule.Name, rule.Operator)
+		}
+		if rule.Window < 0 {
+			return nil, fmt.Errorf("alerting rule %s: negative window", rule.Name)
+		}
+		if rule.Window == 0 {
+			rule.Window = DefaultWindow
+		}
+		checked = append(checked, rule)
+	}
+
+	return &Engine{
+		mutex:     new(sync.Mutex),
+		rules:     checked,
+		sources:   make(map[string]Source),
+		notifiers: notifiers,
+		states:    make(map[string]*alertState),
+		raised:    make(map[string]time.Time),
+	}, nil
+}
+
+// RegisterSource register a Source of metric values
+func (e *Engine) RegisterSource(metric string, source Source) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.sources[metric] = source
+}
+
+// GetFiringCount return the number of firing alerts
+func (e *Engine) GetFiringCount() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	count := 0
+	for _, state := range e.states {
+		if state.firing {
+			count++
+		}
+	}
+	return count
+}
+
+// Raise notify an alert detected outside of the rules (ex: fraud detection),
+// an alert raised again for the same rule and key within RaiseDedupPeriod is not notified
+func (e *Engine) Raise(rule string, key string, value float64, message string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	for id, at := range e.raised {
+		if now.Sub(at) >= RaiseDedupPeriod {
+			delete(e.raised, id)
+		}
+	}
+
+	id := rule + "|" + key
+	if _, found := e.raised[id]; found {
+		return
+	}
+	e.raised[id] = now
+	e.notify(&Alert{Rule: rule, Key: key, State: StateFiring, Value: value, Message: message, At: now})
+}
+
+// Evaluate all rules and notify the alerts which started or stopped firing
+func (e *Engine) Evaluate() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	for _, rule := range e.rules {
+		source, found := e.sources[rule.Metric]
+		if !found {
+			logging.Error.Println("alerting rule", rule.Name, ": unknown metric", rule.Metric)
+			continue
+		}
+
+		values := source(time.Duration(rule.Window)*time.Second, rule.Params)
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			e.evaluate(rule, key, values[key], now)
+		}
+
+		// keys which disappeared from the source are resolved
+		for id, state := range e.states {
+			ruleName, key := splitStateID(id)
+			if ruleName != rule.Name {
+				continue
+			}
+			if _, found := values[key]; found {
+				continue
+			}
+			if state.firing {
+				e.notify(e.newAlert(rule, key, StateResolved, 0, now))
+			}
+			delete(e.states, id)
+		}
+	}
+}
+
+func (e *Engine) evaluate(rule Rule, key string, value float64, now time.Time) {
+	id := rule.Name + "|" + key
+	state, found := e.states[id]
+	if !found {
+		state = &alertState{}
+		e.states[id] = state
+	}
+
+	if !state.firing {
+		if !compare(rule.Operator, value, rule.Threshold) {
+			state.pendingSince = time.Time{}
+			return
+		}
+		if state.pendingSince.IsZero() {
+			state.pendingSince = now
+		}
+		if now.Sub(state.pendingSince) < time.Duration(rule.For)*time.Second {
+			return
+		}
+		state.firing = true
+		state.notifiedAt = now
+		e.notify(e.newAlert(rule, key, StateFiring, value, now))
+		return
+	}
+
+	resolve := rule.Threshold
+	if rule.Resolve != nil {
+		resolve = *rule.Resolve
+	}
+	if compare(rule.Operator, value, resolve) {
+		if rule.Repeat > 0 && now.Sub(state.notifiedAt) >= time.Duration(rule.Repeat)*time.Second {
+			state.notifiedAt = now
+			e.notify(e.newAlert(rule, key, StateFiring, value, now))
+		}
+		return
+	}
+	state.firing = false
+	state.pendingSince = time.Time{}
+	e.notify(e.newAlert(rule, key, StateResolved, value, now))
+}
+
+func (e *Engine) newAlert(rule Rule, key string, state string, value float64, now time.Time) *Alert {
+	return &Alert{
+		Rule:      rule.Name,
+		Key:       key,
+		State:     state,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Message:   fmt.Sprintf("%s %s: %s=%g (threshold %s %g)", rule.Name, key, rule.Metric, value, rule.Operator, rule.Threshold),
+		At:        now,
+	}
+}
+
+func (e *Engine) notify(alert *Alert) {
+	logging.Warning.Println("alert", alert.State, alert.Message)
+	for _, notifier := range e.notifiers {
+		go func(notifier Notifier) {
+			if err := notifier.Notify(alert); err != nil {
+				logging.Error.Println("alert notification failed:", err)
+			}
+		}(notifier)
+	}
+}
+
+func compare(operator string, value float64, threshold float64) bool {
+	if operator == "<" {
+		return value < threshold
+	}
+	return value > threshold
+}
+
+func splitStateID(id string) (string, string) {
+	if i := strings.Index(id, "|"); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return id, ""
+}
@@ -0,0 +1,127 @@
+package alerting
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordNotifier struct {
+	mutex  sync.Mutex
+	alerts []*Alert
+}
+
+func (n *recordNotifier) Notify(alert *Alert) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func (n *recordNotifier) states() []string {
+	<-time.After(time.Millisecond * 10)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	states := make([]string, 0, len(n.alerts))
+	for _, alert := range n.alerts {
+		states = append(states, alert.Key+":"+alert.State)
+	}
+	return states
+}
+
+func TestNewEngineRejectInvalidRules(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewEngine([]Rule{{Name: "asr", Metric: "asr", Operator: "<="}}, nil)
+	assert.NotNil(err, "unknown operator must be rejected")
+
+	_, err = NewEngine([]Rule{{Metric: "asr", Operator: "<"}}, nil)
+	assert.NotNil(err, "rule without name must be rejected")
+
+	_, err = NewEngine([]Rule{{Name: "asr", Metric: "asr", Operator: "<", Window: -60}}, nil)
+	assert.NotNil(err, "negative window must be rejected")
+}
+
+func TestEngineDefaultWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	engine, err := NewEngine([]Rule{
+		{Name: "asr_low", Metric: "asr", Operator: "<", Threshold: 40},
+		{Name: "asr_1m", Metric: "asr", Operator: "<", Threshold: 40, Window: 60},
+	}, nil)
+	assert.Nil(err, "rules must be valid")
+
+	windows := []time.Duration{}
+	engine.RegisterSource("asr", func(window time.Duration, params map[string]string) map[string]float64 {
+		windows = append(windows, window)
+		return nil
+	})
+	engine.Evaluate()
+	assert.Equal([]time.Duration{DefaultWindow * time.Second, time.Minute}, windows, "rule windows not applied")
+}
+
+func TestEngineHysteresis(t *testing.T) {
+	assert := assert.New(t)
+	notifier := &recordNotifier{}
+	resolve := float64(50)
+
+	engine, err := NewEngine([]Rule{
+		{Name: "asr_low", Metric: "asr", Operator: "<", Threshold: 40, Resolve: &resolve},
+	}, []Notifier{notifier})
+	assert.Nil(err, "rule must be valid")
+
+	value := float64(60)
+	engine.RegisterSource("asr", func(window time.Duration, params map[string]string) map[string]float64 {
+		return map[string]float64{"provider": value}
+	})
+
+	engine.Evaluate()
+	assert.Empty(notifier.states(), "no alert expected")
+
+	value = 30
+	engine.Evaluate()
+	engine.Evaluate()
+	assert.Equal([]string{"provider:firing"}, notifier.states(), "alert must fire once")
+	assert.Equal(1, engine.GetFiringCount(), "1 firing alert expected")
+
+	value = 45
+	engine.Evaluate()
+	assert.Equal([]string{"provider:firing"}, notifier.states(), "alert must not resolve under the resolve threshold")
+
+	value = 55
+	engine.Evaluate()
+	assert.Equal([]string{"provider:firing", "provider:resolved"}, notifier.states(), "alert must resolve")
+	assert.Equal(0, engine.GetFiringCount(), "no firing alert expected")
+}
+
+func TestEngineFor(t *testing.T) {
+	assert := assert.New(t)
+	notifier := &recordNotifier{}
+
+	engine, _ := NewEngine([]Rule{
+		{Name: "concurrent_high", Metric: "concurrent", Operator: ">", Threshold: 10, For: 1},
+	}, []Notifier{notifier})
+	engine.RegisterSource("concurrent", func(window time.Duration, params map[string]string) map[string]float64 {
+		return map[string]float64{"All": 20}
+	})
+
+	engine.Evaluate()
+	assert.Empty(notifier.states(), "condition must hold 1s before firing")
+
+	<-time.After(time.Millisecond * 1100)
+	engine.Evaluate()
+	assert.Equal([]string{"All:firing"}, notifier.states(), "alert must fire")
+}
+
+func TestEngineRaise(t *testing.T) {
+	assert := assert.New(t)
+	notifier := &recordNotifier{}
+	engine, _ := NewEngine(nil, []Notifier{notifier})
+
+	engine.Raise("fraud", "1234", 1, "premium destination")
+	engine.Raise("fraud", "1234", 1, "premium destination")
+	engine.Raise("fraud", "5678", 1, "premium destination")
+	assert.ElementsMatch([]string{"1234:firing", "5678:firing"}, notifier.states(), "raised alerts must be de-duplicated")
+}
@@ -0,0 +1,94 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// WebhookNotifier POST alerts as json to an URL
+type WebhookNotifier struct {
+	URL string `json:"url"`
+	// Timeout seconds, 10 by default
+	Timeout int `json:"timeout"`
+}
+
+// Notify POST the alert
+func (n *WebhookNotifier) Notify(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(n.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	response, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", n.URL, response.Status)
+	}
+	return nil
+}
+
+// ExecNotifier run a local command for each alert
+//
+// the alert is written as json on the command stdin and exposed as ALERT_* environment variables
+type ExecNotifier struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Timeout seconds, 10 by default
+	Timeout int `json:"timeout"`
+}
+
+// Notify run the command
+func (n *ExecNotifier) Notify(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(n.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	cmd := exec.Command(n.Command, n.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"ALERT_RULE="+alert.Rule,
+		"ALERT_KEY="+alert.Key,
+		"ALERT_STATE="+alert.State,
+		fmt.Sprintf("ALERT_VALUE=%g", alert.Value),
+		fmt.Sprintf("ALERT_THRESHOLD=%g", alert.Threshold),
+		"ALERT_MESSAGE="+alert.Message,
+	)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("command %s timed out", n.Command)
+	}
+}
@@ -21,6 +21,15 @@ var errNotEvent = errors.New("Not Event")
 // ErrNotAMI raised when not response expected protocol AMI
 var ErrNotAMI = errors.New("Server not AMI interface")
 
+// ErrActionListTimeout raised when an action list is not complete in time
+var ErrActionListTimeout = errors.New("Action list timeout")
+
+// ErrActionTimeout raised when an action response is not received in time
+var ErrActionTimeout = errors.New("Action timeout")
+
+// ErrNoSuchChannel raised when the channel targeted by an action does not exist
+var ErrNoSuchChannel = errors.New("No such channel")
+
 // Params for the actions
 type Params map[string]string
 
@@ -47,6 +56,12 @@ type Event struct {
 
 type eventHandlerFunc func(*Event)
 
+// eventList events received in response to an action list
+type eventList struct {
+	events   []*Event
+	complete chan struct{}
+}
+
 // Client a connection to Asterisk Manager Interface
 type Client struct {
 	address  string
@@ -60,26 +75,49 @@ type Client struct {
 	tlsConfig   *tls.Config
 
 	// chanActions      chan Action
-	responses map[string]chan *Response
+	responses  map[string]chan *Response
+	eventLists map[string]*eventList
 
-	// Events for client parse
+	// Events for client parse, events are dropped when it is full
 	Events chan *Event
 
-	// Error Raise on logic
+	// Error Raise on logic, errors are dropped when it is full
 	Error chan error
 
 	//NetError a network error
 	NetError chan error
 
-	mutexAsyncAction *sync.RWMutex
-	mutexObject      *sync.RWMutex
+	mutexObject *sync.RWMutex
 
 	// network wait for a new connection
 	waitNewConnection chan struct{}
 
 	defaultHandler    eventHandlerFunc
-	handlers          map[string]eventHandlerFunc
+	handlers          map[string]*Subscription
+	subscriptions     []*Subscription
+	middlewares       []Middleware
+	chain             func(*Event)
+	strict            bool
+	onPanic           func(*Event, interface{})
 	keepAliveExitChan chan bool
+	lastPingRTT       time.Duration
+
+	recorder *recorder
+
+	queueSize      int
+	overflowPolicy OverflowPolicy
+	workers        int
+	shardKey       func(*Event) string
+	dispatchers    []*dispatcher
+
+	filterInclude []string
+	filterExclude []string
+	filterHandled bool
+	mutexFilters  *sync.Mutex
+	filtersSent   map[string]bool
+	allowlist     map[string]bool
+	mutexStats    *sync.Mutex
+	stats         map[string]*EventStats
 }
 
 // UseTLS option which enable tls connection for client
@@ -110,19 +148,28 @@ func New(address string, user string, password string, options ...func(*Client))
 		address:           address,
 		username:          user,
 		password:          password,
-		mutexAsyncAction:  new(sync.RWMutex),
 		mutexObject:       new(sync.RWMutex),
 		waitNewConnection: make(chan struct{}),
 		responses:         make(map[string]chan *Response),
+		eventLists:        make(map[string]*eventList),
 		Events:            nil,
 		Error:             make(chan error, 1),
 		NetError:          make(chan error, 1),
 		useTLS:            false,
 		unsecureTLS:       false,
 		tlsConfig:         new(tls.Config),
-		handlers:          make(map[string]eventHandlerFunc),
+		handlers:          make(map[string]*Subscription),
+		queueSize:         DefaultDispatchQueueSize,
+		overflowPolicy:    OverflowBlock,
+		workers:           1,
+		shardKey:          UniqueIDShardKey,
+		mutexFilters:      new(sync.Mutex),
+		mutexStats:        new(sync.Mutex),
+		stats:             make(map[string]*EventStats),
 	}
 
+	client.chain = client.deliver
+
 	for _, op := range options {
 		op(client)
 	}
@@ -132,18 +179,21 @@ func New(address string, user string, password string, options ...func(*Client))
 // SetEventChannel set a channel to send Event received
 func (client *Client) SetEventChannel(channel chan *Event) {
 	client.mutexObject.Lock()
-	defer client.mutexObject.Unlock()
 	client.Events = channel
+	client.mutexObject.Unlock()
+	client.updateFilters()
 }
 
 // RegisterDefaultHandler register a default handler for all events
 func (client *Client) RegisterDefaultHandler(f eventHandlerFunc) error {
 	client.mutexObject.Lock()
-	defer client.mutexObject.Unlock()
 	if client.defaultHandler != nil {
+		client.mutexObject.Unlock()
 		return errors.New("DefaultHandler already registered")
 	}
 	client.defaultHandler = f
+	client.mutexObject.Unlock()
+	client.updateFilters()
 	return nil
 }
 
@@ -158,25 +208,31 @@ func (client *Client) UnregisterDefaultHandler(f eventHandlerFunc) error {
 	return nil
 }
 
-// RegisterHandler register an handler for a specific event
+// RegisterHandler register an handler for a specific event, one per event: see Subscribe for more
 func (client *Client) RegisterHandler(eventID string, f eventHandlerFunc) error {
 	client.mutexObject.Lock()
-	defer client.mutexObject.Unlock()
 	if client.handlers[eventID] != nil {
+		client.mutexObject.Unlock()
 		return errors.New("Handler already registered")
 	}
-	client.handlers[eventID] = f
+	s := &Subscription{client: client, pattern: eventID, literal: true, handler: f}
+	client.subscriptions = append(client.subscriptions, s)
+	client.handlers[eventID] = s
+	client.mutexObject.Unlock()
+	client.updateFilters()
 	return nil
 }
 
-// UnregisterHandler unregister an handler for a specific event
+// UnregisterHandler unregister the handler registered by RegisterHandler for a specific event
 func (client *Client) UnregisterHandler(eventID string) error {
 	client.mutexObject.Lock()
 	defer client.mutexObject.Unlock()
-	if client.handlers[eventID] == nil {
+	s := client.handlers[eventID]
+	if s == nil {
 		return errors.New("Handler not registered")
 	}
-	client.handlers[eventID] = nil
+	client.removeSubscription(s)
+	delete(client.handlers, eventID)
 	return nil
 }
 
@@ -193,6 +249,11 @@ func (client *Client) Connect(parameters map[string]string) (err error) {
 		return err
 	}
 
+	if client.recorder != nil {
+		client.recorder.reset()
+		client.connRaw = &recordingConn{client.connRaw, client.recorder}
+	}
+
 	client.conn = textproto.NewConn(client.connRaw)
 	label, err := client.conn.ReadLine()
 	if err != nil {
@@ -208,7 +269,7 @@ func (client *Client) Connect(parameters map[string]string) (err error) {
 		return err
 	}
 
-	return nil
+	return client.addFilters()
 }
 
 // KeepAlive periodicaly send "Ping" action to AMI server
@@ -224,7 +285,7 @@ func (client *Client) KeepAlive(interval time.Duration) {
 				return
 			case <-time.After(interval):
 				{
-					if _, err := client.Action("Ping", nil); err != nil {
+					if _, err := client.Ping(); err != nil {
 						client.Close()
 					}
 				}
@@ -233,6 +294,27 @@ func (client *Client) KeepAlive(interval time.Duration) {
 	}(client, interval)
 }
 
+// Ping send a "Ping" action and return the round trip time
+func (client *Client) Ping() (time.Duration, error) {
+	start := time.Now()
+	if _, err := client.Action("Ping", nil); err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	client.lastPingRTT = rtt
+	return rtt, nil
+}
+
+// GetLastPingRTT return the round trip time of the last successful Ping
+func (client *Client) GetLastPingRTT() time.Duration {
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	return client.lastPingRTT
+}
+
 // StopKeepAlive StopKeepAlive gorouting
 func (client *Client) StopKeepAlive() {
 	client.mutexObject.Lock()
@@ -271,37 +353,91 @@ func (client *Client) login(parameters map[string]string) error {
 }
 
 // Run process socket waiting events and responses
+//
+// responses are notified as soon as they are read, events are queued and handled by the dispatch workers,
+// the events still queued are handled before Run returns
 func (client *Client) Run() (err error) {
+	client.startDispatcher()
+	defer client.stopDispatcher()
+
 	for {
 		data, err := client.conn.ReadMIMEHeader()
 		if err != nil {
 			return err
 		}
+		client.dispatch(&data)
+	}
+}
 
-		if response, err := newResponse(&data); err == nil {
-			client.notifyResponse(response)
-			continue
-		}
+// dispatch notify a response or queue an event for its handler
+func (client *Client) dispatch(data *textproto.MIMEHeader) {
+	if response, err := newResponse(data); err == nil {
+		client.notifyResponse(response)
+		return
+	}
 
-		if ev, err := newEvent(&data); err != nil {
-			if err != errNotEvent {
-				fmt.Println(err)
-				client.Error <- err
-			}
-		} else {
-			if client.Events != nil {
-				client.Events <- ev
+	ev, err := newEvent(data)
+	if err != nil {
+		if err != errNotEvent {
+			logging.Error.Println(err)
+			select {
+			case client.Error <- err:
+			default:
 			}
-			event := ev.ID
+		}
+		return
+	}
 
-			if handler, found := client.handlers[event]; found {
-				handler(ev)
-			} else if client.defaultHandler != nil {
-				client.defaultHandler(ev)
-			}
-			continue
+	if client.collectListEvent(ev) {
+		return
+	}
+
+	client.countEvent(ev, func(stats *EventStats) { stats.Received++ })
+	if !client.allowed(ev) {
+		client.countEvent(ev, func(stats *EventStats) { stats.Filtered++ })
+		return
+	}
+	client.enqueue(ev)
+}
+
+// handle dispatch an event through the middlewares, recovering their panics
+func (client *Client) handle(ev *Event) {
+	client.mutexObject.RLock()
+	chain := client.chain
+	client.mutexObject.RUnlock()
+	client.protect(chain, ev)
+}
+
+// deliver send an event to the Events channel and to its handlers
+func (client *Client) deliver(ev *Event) {
+	client.mutexObject.RLock()
+	events := client.Events
+	defaultHandler := client.defaultHandler
+	client.mutexObject.RUnlock()
+	handlers := client.subscribers(ev.ID)
+
+	used := false
+	if events != nil {
+		select {
+		case events <- ev:
+			used = true
+		default:
+			client.countDropped(ev)
 		}
 	}
+
+	// a panicking handler does not prevent the others from handling the event
+	for _, handler := range handlers {
+		used = true
+		client.protect(handler, ev)
+	}
+	if len(handlers) == 0 && defaultHandler != nil {
+		used = true
+		client.protect(defaultHandler, ev)
+	}
+	if used {
+		client.countEvent(ev, func(stats *EventStats) { stats.Used++ })
+	}
 }
 
 // AsyncAction return chan for wait response of action with parameter *ActionID* this can be helpful for
@@ -343,6 +479,120 @@ func (client *Client) Action(action string, params Params) (*Response, error) {
 	return response, nil
 }
 
+// ActionTimeout send an action and wait for its response, at most timeout
+func (client *Client) ActionTimeout(action string, params Params, timeout time.Duration) (*Response, error) {
+	if params == nil {
+		params = Params{}
+	}
+	if _, ok := params["ActionID"]; !ok {
+		params["ActionID"] = "go_ami:" + uuid.NewV4()
+	}
+	actionID := params["ActionID"]
+
+	resp, err := client.AsyncAction(action, params)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case response := <-resp:
+		return response, nil
+	case <-deadline.C:
+		// a response received later is not waited for anymore
+		client.mutexObject.Lock()
+		delete(client.responses, actionID)
+		client.mutexObject.Unlock()
+		return nil, ErrActionTimeout
+	}
+}
+
+// ActionList send an action answered by a list of events (EventList: start)
+// and wait for the list to be complete
+func (client *Client) ActionList(action string, params Params, timeout time.Duration) ([]*Event, error) {
+	if params == nil {
+		params = Params{}
+	}
+	if _, ok := params["ActionID"]; !ok {
+		params["ActionID"] = "go_ami:" + uuid.NewV4()
+	}
+	actionID := params["ActionID"]
+
+	list := &eventList{
+		events:   make([]*Event, 0),
+		complete: make(chan struct{}),
+	}
+	client.mutexObject.Lock()
+	client.eventLists[actionID] = list
+	client.mutexObject.Unlock()
+
+	// a response or list not received in time is not waited for anymore
+	defer func() {
+		client.mutexObject.Lock()
+		delete(client.eventLists, actionID)
+		delete(client.responses, actionID)
+		client.mutexObject.Unlock()
+	}()
+
+	// the response and the list share the timeout
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	resp, err := client.AsyncAction(action, params)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-resp:
+		if response.Status == "Error" {
+			return nil, responseError(response)
+		}
+	case <-deadline.C:
+		return nil, ErrActionListTimeout
+	}
+
+	select {
+	case <-list.complete:
+		return list.events, nil
+	case <-deadline.C:
+		return nil, ErrActionListTimeout
+	}
+}
+
+// responseError return the error of an Error response, ErrNoSuchChannel if its channel does not exist
+func responseError(response *Response) error {
+	message := response.Params["Message"]
+	if strings.EqualFold(message, ErrNoSuchChannel.Error()) {
+		return ErrNoSuchChannel
+	}
+	return errors.New(message)
+}
+
+// collectListEvent add the event to its action list, return false if the event is not part of a list
+func (client *Client) collectListEvent(ev *Event) bool {
+	actionID, ok := ev.Params["Actionid"]
+	if !ok {
+		return false
+	}
+
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	list, found := client.eventLists[actionID]
+	if !found {
+		return false
+	}
+
+	if strings.EqualFold(ev.Params["Eventlist"], "Complete") {
+		delete(client.eventLists, actionID)
+		close(list.complete)
+		return true
+	}
+	list.events = append(list.events, ev)
+	return true
+}
+
 // GetPendingActionsCount return nb responses unproceed
 func (client *Client) GetPendingActionsCount() int {
 	client.mutexObject.Lock()
@@ -364,16 +614,15 @@ func (client *Client) Close() {
 
 func (client *Client) notifyResponse(response *Response) {
 	go func() {
-		client.mutexAsyncAction.RLock()
+		// responses is shared with AsyncAction which holds mutexObject
+		client.mutexObject.Lock()
 		chanResponse, found := client.responses[response.ID]
-		client.mutexAsyncAction.RUnlock()
+		delete(client.responses, response.ID)
+		client.mutexObject.Unlock()
 
 		if found {
 			chanResponse <- response
-			client.mutexAsyncAction.Lock()
-			close(client.responses[response.ID])
-			delete(client.responses, response.ID)
-			client.mutexAsyncAction.Unlock()
+			close(chanResponse)
 		}
 	}()
 }
@@ -0,0 +1,176 @@
+package ami
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *amitest.Server {
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// connect a client and run it, the returned channel receives the Run error
+func connect(t *testing.T, server *amitest.Server) (*Client, chan error) {
+	client := New(server.Addr(), "admin", "secret")
+	if err := client.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Run()
+	}()
+	return client, done
+}
+
+func TestConnect(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	client := New(server.Addr(), "admin", "secret")
+	assert.Nil(client.Connect(map[string]string{"Events": "call"}))
+	assert.Nil(server.WaitConnections(1, time.Second))
+
+	actions := server.Actions()
+	assert.Equal(1, len(actions), "login not sent")
+	assert.Equal("Login", actions[0].Get("Action"), "login not sent")
+	assert.Equal("call", actions[0].Get("Events"), "login parameters not sent")
+
+	client = New(server.Addr(), "admin", "wrong")
+	assert.EqualError(client.Connect(nil), "Authentication failed")
+
+	server.SetBanner("SSH-2.0-OpenSSH")
+	client = New(server.Addr(), "admin", "secret")
+	assert.Equal(ErrNotAMI, client.Connect(nil))
+}
+
+func TestPing(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	client, _ := connect(t, server)
+	defer client.Close()
+
+	server.SetResponseDelay(20 * time.Millisecond)
+	rtt, err := client.Ping()
+	assert.Nil(err)
+	assert.True(rtt >= 20*time.Millisecond, "rtt not correctly measured")
+	assert.Equal(rtt, client.GetLastPingRTT(), "last rtt not correctly set")
+	server.SetResponseDelay(0)
+
+	response, err := client.Action("Unknown", nil)
+	assert.Nil(err)
+	assert.Equal("Error", response.Status, "unknown action should fail")
+}
+
+func TestActionList(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	server.HandleAction("QueueStatus", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("QueueStatusComplete",
+			amitest.Frame{"Event": "QueueParams", "Queue": "support"},
+			amitest.Frame{"Event": "QueueMember", "Queue": "support", "Name": "Alice"},
+		)
+	})
+	server.HandleAction("Slow", func(action amitest.Frame) []amitest.Frame {
+		return []amitest.Frame{amitest.Success("Events will follow")}
+	})
+
+	client, _ := connect(t, server)
+	defer client.Close()
+
+	events, err := client.ActionList("QueueStatus", nil, time.Second)
+	assert.Nil(err)
+	assert.Equal(2, len(events), "list events not correctly collected")
+	assert.Equal("QueueMember", events[1].ID, "list events not correctly collected")
+	assert.Equal("Alice", events[1].Params["Name"], "list events not correctly collected")
+
+	_, err = client.ActionList("Slow", nil, 50*time.Millisecond)
+	assert.Equal(ErrActionListTimeout, err)
+
+	server.HandleAction("Silent", func(action amitest.Frame) []amitest.Frame {
+		return nil
+	})
+	server.HandleAction("Status", func(action amitest.Frame) []amitest.Frame {
+		return []amitest.Frame{amitest.Error("No such channel")}
+	})
+	_, err = client.ActionList("Status", Params{"Channel": "SIP/gone-00000001"}, time.Second)
+	assert.Equal(ErrNoSuchChannel, err, "missing channel not reported")
+
+	pending := client.GetPendingActionsCount()
+	_, err = client.ActionList("Silent", nil, 50*time.Millisecond)
+	assert.Equal(ErrActionListTimeout, err)
+	assert.Equal(pending, client.GetPendingActionsCount(), "unanswered action still pending")
+
+	server.SetResponseDelay(80 * time.Millisecond)
+	start := time.Now()
+	_, err = client.ActionList("Slow", nil, 100*time.Millisecond)
+	assert.Equal(ErrActionListTimeout, err)
+	assert.True(time.Since(start) < 160*time.Millisecond, "response and list should share the timeout")
+}
+
+func TestActionTimeout(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+	server.HandleAction("Silent", func(action amitest.Frame) []amitest.Frame {
+		return nil
+	})
+
+	client, _ := connect(t, server)
+	defer client.Close()
+
+	response, err := client.ActionTimeout("Ping", nil, time.Second)
+	assert.Nil(err)
+	assert.Equal("Success", response.Status, "response not returned")
+
+	pending := client.GetPendingActionsCount()
+	_, err = client.ActionTimeout("Silent", nil, 50*time.Millisecond)
+	assert.Equal(ErrActionTimeout, err)
+	assert.Equal(pending, client.GetPendingActionsCount(), "unanswered action still pending")
+}
+
+func TestEvents(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	client := New(server.Addr(), "admin", "secret")
+	received := make(chan *Event, 10)
+	client.RegisterHandler("Newchannel", func(ev *Event) { received <- ev })
+	client.RegisterDefaultHandler(func(ev *Event) { received <- ev })
+	if err := client.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Run()
+	}()
+
+	assert.Nil(server.Push(amitest.Frame{"Event": "Newchannel", "Uniqueid": "1.1", "CallerIDNum": "100"}))
+	assert.Nil(server.Push(amitest.Frame{"Event": "Hangup", "Uniqueid": "1.1"}))
+
+	ev := <-received
+	assert.Equal("Newchannel", ev.ID, "event not correctly dispatched")
+	assert.Equal("100", ev.Params["Calleridnum"], "event params not correctly set")
+	ev = <-received
+	assert.Equal("Hangup", ev.ID, "default handler not called")
+
+	server.Drop()
+	select {
+	case err := <-done:
+		assert.NotNil(err, "Run should fail when the connection is dropped")
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return when the connection was dropped")
+	}
+}
@@ -0,0 +1,412 @@
+// Package amitest provides a scriptable Asterisk Manager Interface server
+// to test AMI clients without a live Asterisk
+package amitest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBanner sent to each new connection
+const DefaultBanner = "Asterisk Call Manager/2.10.3"
+
+// Frame an action, response or event: header keys and values
+//
+// keys are kept as written, Get looks them up case insensitively
+type Frame map[string]string
+
+// Get return the value of key, whatever its case
+func (f Frame) Get(key string) string {
+	if value, found := f[key]; found {
+		return value
+	}
+	for k, v := range f {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// ActionHandler answer an action with a response frame, optionally followed by events
+//
+// the ActionID of the action is added to the returned frames which do not have one
+type ActionHandler func(action Frame) []Frame
+
+// Success return a successful response
+func Success(message string) Frame {
+	return Frame{"Response": "Success", "Message": message}
+}
+
+// Error return an error response
+func Error(message string) Frame {
+	return Frame{"Response": "Error", "Message": message}
+}
+
+// EventList return the frames answering an action list: a response, the events
+// and the complete event, all with EventList headers
+func EventList(complete string, events ...Frame) []Frame {
+	frames := make([]Frame, 0, len(events)+2)
+	response := Success("Events will follow")
+	response["EventList"] = "start"
+	frames = append(frames, response)
+	for _, ev := range events {
+		frames = append(frames, ev)
+	}
+	frames = append(frames, Frame{
+		"Event":     complete,
+		"EventList": "Complete",
+		"ListItems": fmt.Sprint(len(events)),
+	})
+	return frames
+}
+
+// Server a fake AMI server listening on a local TCP port
+//
+// Login, Ping, Filter and Logoff are answered, other actions with an error unless a handler is registered
+type Server struct {
+	username string
+	password string
+	listener net.Listener
+
+	mutex    *sync.Mutex
+	banner   string
+	conns    map[*conn]bool
+	handlers map[string]ActionHandler
+	delay    time.Duration
+	actions  []Frame
+}
+
+// conn a client connection
+type conn struct {
+	raw      net.Conn
+	mutex    *sync.Mutex
+	loggedIn bool
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+}
+
+// NewServer start a Server on a random local port accepting username / password
+func NewServer(username string, password string) (*Server, error) {
+	return Listen("127.0.0.1:0", username, password)
+}
+
+// Listen start a Server on address accepting username / password
+func Listen(address string, username string, password string) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		username: username,
+		password: password,
+		listener: listener,
+		mutex:    new(sync.Mutex),
+		banner:   DefaultBanner,
+		conns:    make(map[*conn]bool),
+		handlers: make(map[string]ActionHandler),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Addr return the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stop listening and drop the connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Drop()
+}
+
+// SetBanner set the banner sent to the next connections
+func (s *Server) SetBanner(banner string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.banner = banner
+}
+
+// HandleAction register the handler answering an action, replacing the default one
+func (s *Server) HandleAction(action string, handler ActionHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[strings.ToLower(action)] = handler
+}
+
+// SetResponseDelay delay every response, including Login
+func (s *Server) SetResponseDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delay = delay
+}
+
+// Actions return the actions received so far
+func (s *Server) Actions() []Frame {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	actions := make([]Frame, len(s.actions))
+	copy(actions, s.actions)
+	return actions
+}
+
+// Connections return the number of logged in connections
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for c := range s.conns {
+		if c.loggedIn {
+			count++
+		}
+	}
+	return count
+}
+
+// WaitConnections wait until at least n connections are logged in
+func (s *Server) WaitConnections(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for s.Connections() < n {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d connections logged in, %d expected", s.Connections(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+// Push send an event to every logged in connection whose filters accept it
+func (s *Server) Push(event Frame) error {
+	s.mutex.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		if c.loggedIn {
+			conns = append(conns, c)
+		}
+	}
+	s.mutex.Unlock()
+
+	if len(conns) == 0 {
+		return errors.New("no connection logged in")
+	}
+	text := format(event)
+	for _, c := range conns {
+		if !s.accept(c, text) {
+			continue
+		}
+		if err := c.write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drop close every connection, as if Asterisk was restarted
+func (s *Server) Drop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.raw.Close()
+		delete(s.conns, c)
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		raw, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{raw: raw, mutex: new(sync.Mutex)}
+		s.mutex.Lock()
+		s.conns[c] = true
+		s.mutex.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *conn) {
+	defer func() {
+		c.raw.Close()
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+	}()
+
+	s.mutex.Lock()
+	banner := s.banner
+	s.mutex.Unlock()
+	if _, err := fmt.Fprintf(c.raw, "%s\r\n", banner); err != nil {
+		return
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(c.raw))
+	for {
+		action, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		if len(action) == 0 {
+			continue
+		}
+
+		s.mutex.Lock()
+		s.actions = append(s.actions, action)
+		delay := s.delay
+		s.mutex.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		frames, closing := s.answer(c, action)
+		for _, frame := range frames {
+			if id := action.Get("ActionID"); id != "" && frame.Get("ActionID") == "" {
+				frame["ActionID"] = id
+			}
+			if err := c.write(frame); err != nil {
+				return
+			}
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+// answer return the frames answering an action and whether the connection must be closed
+func (s *Server) answer(c *conn, action Frame) ([]Frame, bool) {
+	name := strings.ToLower(action.Get("Action"))
+
+	s.mutex.Lock()
+	handler, found := s.handlers[name]
+	s.mutex.Unlock()
+	if found {
+		return handler(action), false
+	}
+
+	switch name {
+	case "login":
+		if action.Get("Username") != s.username || action.Get("Secret") != s.password {
+			return []Frame{Error("Authentication failed")}, true
+		}
+		s.mutex.Lock()
+		c.loggedIn = true
+		s.mutex.Unlock()
+		return []Frame{Success("Authentication accepted")}, false
+	case "ping":
+		return []Frame{{
+			"Response":  "Success",
+			"Ping":      "Pong",
+			"Timestamp": fmt.Sprintf("%.6f", float64(time.Now().UnixNano())/1e9),
+		}}, false
+	case "filter":
+		return []Frame{s.addFilter(c, action)}, false
+	case "logoff":
+		return []Frame{{"Response": "Goodbye", "Message": "Thanks for all the fish."}}, true
+	}
+	return []Frame{Error("Invalid/unknown command")}, false
+}
+
+// addFilter add the include filter, or exclude filter if it starts with !, of a Filter action
+func (s *Server) addFilter(c *conn, action Frame) Frame {
+	if !strings.EqualFold(action.Get("Operation"), "Add") {
+		return Error("Unknown operation")
+	}
+	filter := action.Get("Filter")
+	exclude := strings.HasPrefix(filter, "!")
+	re, err := regexp.Compile(strings.TrimPrefix(filter, "!"))
+	if err != nil {
+		return Error("Filter Failed")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if exclude {
+		c.exclude = append(c.exclude, re)
+	} else {
+		c.include = append(c.include, re)
+	}
+	return Success("Filter Added Successfully")
+}
+
+// accept return true if the event text passes the filters of the connection, as Asterisk does:
+// matching an include filter if there is one, and no exclude filter
+func (s *Server) accept(c *conn, text string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, re := range c.exclude {
+		if re.MatchString(text) {
+			return false
+		}
+	}
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, re := range c.include {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// write send a frame
+func (c *conn) write(frame Frame) error {
+	output := format(frame)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.raw.Write([]byte(output))
+	return err
+}
+
+// format return the text of a frame, Response or Event header first
+func format(frame Frame) string {
+	keys := make([]string, 0, len(frame))
+	for k := range frame {
+		if k != "Response" && k != "Event" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	output := ""
+	for _, k := range []string{"Response", "Event"} {
+		if v, found := frame[k]; found {
+			output += k + ": " + v + "\r\n"
+		}
+	}
+	for _, k := range keys {
+		output += k + ": " + frame[k] + "\r\n"
+	}
+	return output + "\r\n"
+}
+
+// readFrame read header lines up to a blank line, keeping the keys as written
+func readFrame(reader *textproto.Reader) (Frame, error) {
+	frame := make(Frame)
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return frame, nil
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		frame[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+}
@@ -0,0 +1,347 @@
+package ami
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// OverflowPolicy what to do with an event received while the dispatch queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock wait for room in the queue, the socket is not read meanwhile
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drop the oldest queued event to make room
+	OverflowDropOldest
+	// OverflowDropNewest drop the received event
+	OverflowDropNewest
+)
+
+// DefaultDispatchQueueSize number of events received but not yet handled before the overflow policy applies
+const DefaultDispatchQueueSize = 10000
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDropNewest: "drop-newest",
+}
+
+func (p OverflowPolicy) String() string {
+	return overflowPolicyNames[p]
+}
+
+// ParseOverflowPolicy return the policy named block, drop-oldest or drop-newest
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for policy, n := range overflowPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return OverflowBlock, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// DispatchQueue return an option to set the size and overflow policy of the dispatch queue
+//
+// events are read from the socket by Run and handled by the dispatch workers,
+// in the order they were received
+func DispatchQueue(size int, policy OverflowPolicy) func(*Client) {
+	return func(c *Client) {
+		if size < 1 {
+			size = 1
+		}
+		c.queueSize = size
+		c.overflowPolicy = policy
+	}
+}
+
+// DispatchWorkers return an option to handle the events with n goroutines
+//
+// events are spread over the workers by the ShardBy key: events with the same key are handled in order
+// by the same worker, the others concurrently. The dispatch queue size is shared between the workers
+func DispatchWorkers(n int) func(*Client) {
+	return func(c *Client) {
+		if n < 1 {
+			n = 1
+		}
+		c.workers = n
+	}
+}
+
+// ShardBy return an option to set the key spreading the events over the workers, UniqueIDShardKey by default
+func ShardBy(key func(*Event) string) func(*Client) {
+	return func(c *Client) {
+		c.shardKey = key
+	}
+}
+
+// LinkedIDShardKey key the events by the Linkedid of the call, falling back to its Uniqueid:
+// all the channels of a call are handled by the same worker.
+// Asterisk may change the Linkedid of a channel during transfers, its next events can then be handled by another
+// worker before the previous ones: the handlers must not rely on the order of the events of a channel
+func LinkedIDShardKey(ev *Event) string {
+	return firstParam(ev, "Linkedid", "Transfereelinkedid", "Uniqueid", "Transfereeuniqueid")
+}
+
+// UniqueIDShardKey key the events by the Uniqueid of the channel: each channel events are handled in order
+func UniqueIDShardKey(ev *Event) string {
+	return firstParam(ev, "Uniqueid", "Transfereeuniqueid")
+}
+
+// ParseShardKey return the shard key named linkedid or uniqueid
+func ParseShardKey(name string) (func(*Event) string, error) {
+	switch name {
+	case "linkedid":
+		return LinkedIDShardKey, nil
+	case "uniqueid":
+		return UniqueIDShardKey, nil
+	}
+	return nil, fmt.Errorf("unknown shard key %q", name)
+}
+
+func firstParam(ev *Event, keys ...string) string {
+	for _, key := range keys {
+		if value := ev.Params[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dispatchItem a queued event, or a flush marker closed once the events queued before it are handled
+type dispatchItem struct {
+	event   *Event
+	flushed chan struct{}
+}
+
+// dispatcher a bounded queue of events handled by one goroutine
+type dispatcher struct {
+	size   int
+	policy OverflowPolicy
+	drop   func(*Event)
+
+	mutex  *sync.Mutex
+	cond   *sync.Cond
+	items  []dispatchItem
+	closed bool
+	done   chan struct{}
+}
+
+// newDispatcher start a goroutine calling handle for each queued event, drop is called for the dropped ones
+func newDispatcher(size int, policy OverflowPolicy, handle func(*Event), drop func(*Event)) *dispatcher {
+	d := &dispatcher{
+		size:   size,
+		policy: policy,
+		drop:   drop,
+		mutex:  new(sync.Mutex),
+		items:  make([]dispatchItem, 0, size),
+		done:   make(chan struct{}),
+	}
+	d.cond = sync.NewCond(d.mutex)
+
+	go func() {
+		defer close(d.done)
+		for {
+			item, ok := d.pop()
+			if !ok {
+				return
+			}
+			if item.event != nil {
+				handle(item.event)
+			} else {
+				close(item.flushed)
+			}
+		}
+	}()
+	return d
+}
+
+// push queue an event according to the overflow policy
+func (d *dispatcher) push(ev *Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for d.policy == OverflowBlock && len(d.items) >= d.size && !d.closed {
+		d.cond.Wait()
+	}
+	if d.closed {
+		d.drop(ev)
+		return
+	}
+
+	if len(d.items) >= d.size {
+		switch d.policy {
+		case OverflowDropNewest:
+			d.drop(ev)
+			return
+		case OverflowDropOldest:
+			for i, item := range d.items {
+				if item.event != nil {
+					d.drop(item.event)
+					d.items = append(d.items[:i], d.items[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	d.items = append(d.items, dispatchItem{event: ev})
+	d.cond.Broadcast()
+}
+
+// flush return a channel closed once the events queued so far are handled
+func (d *dispatcher) flush() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	flushed := make(chan struct{})
+	if d.closed {
+		close(flushed)
+		return flushed
+	}
+	// markers are never dropped nor blocked
+	d.items = append(d.items, dispatchItem{flushed: flushed})
+	d.cond.Broadcast()
+	return flushed
+}
+
+// pop wait for the next item, false once closed and empty
+func (d *dispatcher) pop() (dispatchItem, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for len(d.items) == 0 && !d.closed {
+		d.cond.Wait()
+	}
+	if len(d.items) == 0 {
+		return dispatchItem{}, false
+	}
+	item := d.items[0]
+	d.items[0] = dispatchItem{}
+	d.items = d.items[1:]
+	d.cond.Broadcast()
+	return item, true
+}
+
+// len return the number of queued items
+func (d *dispatcher) len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.items)
+}
+
+// close stop accepting events and wait until the queued ones are handled
+func (d *dispatcher) close() {
+	d.mutex.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mutex.Unlock()
+	<-d.done
+}
+
+// startDispatcher start handling the events queued by dispatch
+func (client *Client) startDispatcher() {
+	size := client.queueSize / client.workers
+	if size < 1 {
+		size = 1
+	}
+	dispatchers := make([]*dispatcher, client.workers)
+	for i := range dispatchers {
+		dispatchers[i] = newDispatcher(size, client.overflowPolicy, client.handle, client.countDropped)
+	}
+
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	client.dispatchers = dispatchers
+}
+
+// stopDispatcher wait for the queued events to be handled and stop the dispatchers
+func (client *Client) stopDispatcher() {
+	client.mutexObject.Lock()
+	dispatchers := client.dispatchers
+	client.dispatchers = nil
+	client.mutexObject.Unlock()
+
+	for _, d := range dispatchers {
+		d.close()
+	}
+}
+
+func (client *Client) getDispatchers() []*dispatcher {
+	client.mutexObject.RLock()
+	defer client.mutexObject.RUnlock()
+	return client.dispatchers
+}
+
+// enqueue queue an event to the worker of its shard key, handle it at once if Run is not running
+func (client *Client) enqueue(ev *Event) {
+	dispatchers := client.getDispatchers()
+	if len(dispatchers) == 0 {
+		client.handle(ev)
+		return
+	}
+
+	shard := 0
+	if len(dispatchers) > 1 {
+		hash := fnv.New32a()
+		hash.Write([]byte(client.shardKey(ev)))
+		shard = int(hash.Sum32() % uint32(len(dispatchers)))
+	}
+	dispatchers[shard].push(ev)
+}
+
+// Flush wait until the events received so far are handled
+func (client *Client) Flush() {
+	dispatchers := client.getDispatchers()
+	flushed := make([]<-chan struct{}, len(dispatchers))
+	for i, d := range dispatchers {
+		flushed[i] = d.flush()
+	}
+	for _, f := range flushed {
+		<-f
+	}
+}
+
+// countDropped count an event dropped because the dispatch queue or the Events channel was full
+func (client *Client) countDropped(ev *Event) {
+	client.countEvent(ev, func(stats *EventStats) { stats.Dropped++ })
+}
+
+// GetQueuedEventsCount return the number of events received but not yet handled
+func (client *Client) GetQueuedEventsCount() int {
+	count := 0
+	for _, d := range client.getDispatchers() {
+		count += d.len()
+	}
+	return count
+}
+
+// GetDroppedEventsCount return the number of events dropped since the client was created
+func (client *Client) GetDroppedEventsCount() uint64 {
+	var count uint64
+	for _, stats := range client.GetEventStats() {
+		count += stats.Dropped
+	}
+	return count
+}
+
+// GetDroppedEvents return the number of events dropped per event type
+func (client *Client) GetDroppedEvents() map[string]uint64 {
+	dropped := make(map[string]uint64)
+	for k, v := range client.GetEventStats() {
+		if v.Dropped > 0 {
+			dropped[k] = v.Dropped
+		}
+	}
+	return dropped
+}
+
+// DumpDropped dump the dropped events per event type
+func DumpDropped(client *Client, logger *log.Logger) {
+	dropped := client.GetDroppedEvents()
+	logger.Println(len(dropped), " dropped event types")
+	for k, v := range dropped {
+		logger.Printf("%s => %d\n", k, v)
+	}
+}
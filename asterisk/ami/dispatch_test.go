@@ -0,0 +1,156 @@
+package ami
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedDispatcher return a dispatcher whose handler waits for release, with the first event being handled
+func blockedDispatcher(size int, policy OverflowPolicy) (*dispatcher, *[]string, *[]string, chan struct{}) {
+	mutex := new(sync.Mutex)
+	handled := make([]string, 0)
+	dropped := make([]string, 0)
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	d := newDispatcher(size, policy, func(ev *Event) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		mutex.Lock()
+		handled = append(handled, ev.Params["Uniqueid"])
+		mutex.Unlock()
+	}, func(ev *Event) {
+		mutex.Lock()
+		dropped = append(dropped, ev.Params["Uniqueid"])
+		mutex.Unlock()
+	})
+
+	d.push(newTestEvent(0))
+	<-started
+	return d, &handled, &dropped, release
+}
+
+func newTestEvent(i int) *Event {
+	return &Event{ID: "Newchannel", Params: map[string]string{"Uniqueid": fmt.Sprint(i)}}
+}
+
+func TestDispatchDropNewest(t *testing.T) {
+	assert := assert.New(t)
+	d, handled, dropped, release := blockedDispatcher(2, OverflowDropNewest)
+
+	for i := 1; i <= 4; i++ {
+		d.push(newTestEvent(i))
+	}
+	assert.Equal(2, d.len(), "queue should be bounded")
+	close(release)
+	d.close()
+
+	assert.Equal([]string{"0", "1", "2"}, *handled, "events not handled in order")
+	assert.Equal([]string{"3", "4"}, *dropped, "newest events not dropped")
+}
+
+func TestDispatchDropOldest(t *testing.T) {
+	assert := assert.New(t)
+	d, handled, dropped, release := blockedDispatcher(2, OverflowDropOldest)
+
+	for i := 1; i <= 4; i++ {
+		d.push(newTestEvent(i))
+	}
+	close(release)
+	d.close()
+
+	assert.Equal([]string{"0", "3", "4"}, *handled, "events not handled in order")
+	assert.Equal([]string{"1", "2"}, *dropped, "oldest events not dropped")
+}
+
+func TestDispatchBlock(t *testing.T) {
+	assert := assert.New(t)
+	d, handled, dropped, release := blockedDispatcher(1, OverflowBlock)
+
+	d.push(newTestEvent(1))
+	pushed := make(chan struct{})
+	go func() {
+		d.push(newTestEvent(2))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	flushed := d.flush()
+	close(release)
+	<-pushed
+	<-flushed
+	d.close()
+
+	assert.Equal([]string{"0", "1", "2"}, *handled, "events not handled in order")
+	assert.Empty(*dropped, "no event should be dropped")
+}
+
+func TestClientCountDropped(t *testing.T) {
+	assert := assert.New(t)
+
+	client := New("", "", "", DispatchQueue(10, OverflowDropNewest))
+	client.SetEventChannel(make(chan *Event, 1))
+	client.startDispatcher()
+	client.enqueue(newTestEvent(1))
+	client.enqueue(newTestEvent(2))
+	client.Flush()
+	client.stopDispatcher()
+
+	assert.Equal(uint64(1), client.GetDroppedEventsCount(), "full Events channel should drop")
+	assert.Equal(map[string]uint64{"Newchannel": 1}, client.GetDroppedEvents(), "dropped events not counted per type")
+	assert.Equal(0, client.GetQueuedEventsCount(), "queue should be empty")
+}
+
+func TestDispatchWorkersKeepKeyOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	mutex := new(sync.Mutex)
+	handled := make(map[string][]string)
+	client := New("", "", "", DispatchWorkers(4))
+	client.RegisterDefaultHandler(func(ev *Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		uniqueID := ev.Params["Uniqueid"]
+		handled[uniqueID] = append(handled[uniqueID], ev.ID)
+	})
+
+	client.startDispatcher()
+	for i := 0; i < 100; i++ {
+		for _, id := range []string{"Newchannel", "Newstate", "Hangup"} {
+			client.enqueue(&Event{ID: id, Params: map[string]string{"Uniqueid": fmt.Sprint(i)}})
+		}
+	}
+	client.stopDispatcher()
+
+	assert.Equal(100, len(handled), "events not handled")
+	for uniqueID, events := range handled {
+		assert.Equal([]string{"Newchannel", "Newstate", "Hangup"}, events, "events of "+uniqueID+" not handled in order")
+	}
+}
+
+func TestShardKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	transfer := &Event{ID: "BlindTransfer", Params: map[string]string{"Transfereeuniqueid": "1.2", "Transfereelinkedid": "1.1"}}
+	assert.Equal("1.1", LinkedIDShardKey(transfer), "transferee linkedid not used")
+	assert.Equal("1.2", UniqueIDShardKey(transfer), "transferee uniqueid not used")
+
+	channel := &Event{ID: "Newchannel", Params: map[string]string{"Uniqueid": "1.2", "Linkedid": "1.1"}}
+	assert.Equal("1.1", LinkedIDShardKey(channel), "linkedid not used")
+	assert.Equal("1.2", UniqueIDShardKey(channel), "uniqueid not used")
+
+	_, err := ParseShardKey("channel")
+	assert.NotNil(err, "unknown shard key should fail")
+}
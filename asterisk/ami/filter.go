@@ -0,0 +1,211 @@
+package ami
+
+import (
+	"log"
+	"sort"
+
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+)
+
+// EventStats counters of an event type
+type EventStats struct {
+	// Received events read from AMI, list events answering an action excluded
+	Received uint64
+	// Filtered events discarded by the allowlist
+	Filtered uint64
+	// Dropped events dropped because the dispatch queue or the Events channel was full
+	Dropped uint64
+	// Used events sent to a handler or to the Events channel
+	Used uint64
+	// Panics panics recovered while handling the events
+	Panics uint64
+}
+
+// Filters return an option adding AMI Filter actions at login
+//
+// include and exclude are regular expressions matched by Asterisk against the whole event text,
+// "Event: Newchannel" for example. Asterisk only sends the events matching an include filter
+// if there is one, and never the events matching an exclude filter
+func Filters(include []string, exclude []string) func(*Client) {
+	return func(c *Client) {
+		c.filterInclude = append(c.filterInclude, include...)
+		c.filterExclude = append(c.filterExclude, exclude...)
+	}
+}
+
+// FilterHandledEvents option adding an include Filter action at login for each subscribed event or glob
+//
+// no filter is added when a default handler or an Events channel is set, or an handler subscribed to "*":
+// they need every event. Once logged in, a new subscription adds the Filter action of its events, or one
+// matching every event if it needs them all
+func FilterHandledEvents(c *Client) {
+	c.filterHandled = true
+}
+
+// AllowEvents return an option discarding the events whose type is not in ids before they are queued
+func AllowEvents(ids ...string) func(*Client) {
+	return func(c *Client) {
+		if c.allowlist == nil {
+			c.allowlist = make(map[string]bool)
+		}
+		for _, id := range ids {
+			c.allowlist[id] = true
+		}
+	}
+}
+
+// allEventsFilter include filter matching every event
+const allEventsFilter = "Event: .*"
+
+// eventFilters return the include and exclude filters to add at login
+func (client *Client) eventFilters() ([]string, []string) {
+	client.mutexObject.RLock()
+	defer client.mutexObject.RUnlock()
+
+	include := append([]string{}, client.filterInclude...)
+	if client.filterHandled {
+		if handled, ok := client.handledFilters(); ok {
+			include = append(include, handled...)
+		}
+	}
+	return include, append([]string{}, client.filterExclude...)
+}
+
+// handledFilters return the include filters of the subscribed events, false if every event is needed,
+// mutexObject must be held
+func (client *Client) handledFilters() ([]string, bool) {
+	if client.defaultHandler != nil || client.Events != nil {
+		return nil, false
+	}
+	subscribed, ok := client.subscribedEvents()
+	if !ok {
+		return nil, false
+	}
+	sort.Strings(subscribed)
+	return subscribed, true
+}
+
+// addFilters send the Filter actions at login, their responses are checked once Run reads them
+func (client *Client) addFilters() error {
+	client.mutexFilters.Lock()
+	defer client.mutexFilters.Unlock()
+
+	include, exclude := client.eventFilters()
+	filters := make([]string, 0, len(include)+len(exclude))
+	filters = append(filters, include...)
+	for _, filter := range exclude {
+		filters = append(filters, "!"+filter)
+	}
+
+	// once the handled events are filtered, the events subscribed later need their own filter
+	client.mutexObject.RLock()
+	handled, ok := client.handledFilters()
+	client.mutexObject.RUnlock()
+	client.filtersSent = nil
+	if client.filterHandled && ok && len(handled) > 0 {
+		client.filtersSent = make(map[string]bool)
+	}
+	return client.sendFilters(filters)
+}
+
+// updateFilters send the include Filter actions of the events subscribed since login,
+// when the handled events filters were added at login Asterisk would not send them otherwise
+func (client *Client) updateFilters() {
+	client.mutexFilters.Lock()
+	defer client.mutexFilters.Unlock()
+	if client.filtersSent == nil || client.filtersSent[allEventsFilter] {
+		return
+	}
+
+	client.mutexObject.RLock()
+	handled, ok := client.handledFilters()
+	client.mutexObject.RUnlock()
+	if !ok {
+		handled = []string{allEventsFilter}
+	}
+
+	filters := make([]string, 0)
+	for _, filter := range handled {
+		if !client.filtersSent[filter] {
+			filters = append(filters, filter)
+		}
+	}
+	if err := client.sendFilters(filters); err != nil {
+		logging.Warning.Println("AMI filters", filters, "not added:", err)
+	}
+}
+
+// sendFilters send the Filter actions, mutexFilters must be held
+func (client *Client) sendFilters(filters []string) error {
+	for _, filter := range filters {
+		if client.filtersSent != nil {
+			client.filtersSent[filter] = true
+		}
+		response, err := client.AsyncAction("Filter", Params{"Operation": "Add", "Filter": filter})
+		if err != nil {
+			return err
+		}
+		go func(filter string, response <-chan *Response) {
+			if r := <-response; r != nil && r.Status != "Success" {
+				logging.Warning.Println("AMI filter", filter, "not added:", r.Params["Message"])
+			}
+		}(filter, response)
+	}
+	return nil
+}
+
+// allowed return false if the event is discarded by the allowlist
+func (client *Client) allowed(ev *Event) bool {
+	return client.allowlist == nil || client.allowlist[ev.ID]
+}
+
+// countEvent increment a counter of the event type
+func (client *Client) countEvent(ev *Event, count func(*EventStats)) {
+	client.mutexStats.Lock()
+	defer client.mutexStats.Unlock()
+	stats, found := client.stats[ev.ID]
+	if !found {
+		stats = &EventStats{}
+		client.stats[ev.ID] = stats
+	}
+	count(stats)
+}
+
+// GetEventStats return the counters per event type since the client was created
+func (client *Client) GetEventStats() map[string]EventStats {
+	client.mutexStats.Lock()
+	defer client.mutexStats.Unlock()
+	stats := make(map[string]EventStats, len(client.stats))
+	for k, v := range client.stats {
+		stats[k] = *v
+	}
+	return stats
+}
+
+// GetReceivedEventsCount return the number of events received since the client was created
+func (client *Client) GetReceivedEventsCount() uint64 {
+	var count uint64
+	for _, stats := range client.GetEventStats() {
+		count += stats.Received
+	}
+	return count
+}
+
+// GetUsedEventsCount return the number of events sent to a handler or to the Events channel
+func (client *Client) GetUsedEventsCount() uint64 {
+	var count uint64
+	for _, stats := range client.GetEventStats() {
+		count += stats.Used
+	}
+	return count
+}
+
+// DumpEventStats dump the counters per event type
+func DumpEventStats(client *Client, logger *log.Logger) {
+	stats := client.GetEventStats()
+	logger.Println(len(stats), " event types")
+	for k, v := range stats {
+		logger.Printf("%s => received: %d, filtered: %d, dropped: %d, used: %d, panics: %d\n",
+			k, v.Received, v.Filtered, v.Dropped, v.Used, v.Panics)
+	}
+}
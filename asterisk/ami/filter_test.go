@@ -0,0 +1,160 @@
+package ami
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectWith connect a client created with options and run it once the server has answered its first actions
+func connectWith(t *testing.T, server *amitest.Server, register func(*Client), options ...func(*Client)) *Client {
+	client := New(server.Addr(), "admin", "secret", options...)
+	register(client)
+	if err := client.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go client.Run()
+	// actions are answered in order: the filters are added once the ping is answered
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func filterActions(server *amitest.Server) []string {
+	filters := make([]string, 0)
+	for _, action := range server.Actions() {
+		if action.Get("Action") == "Filter" {
+			filters = append(filters, action.Get("Filter"))
+		}
+	}
+	return filters
+}
+
+func TestFilterHandledEvents(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	mutex := new(sync.Mutex)
+	handled := make([]string, 0)
+	handler := func(ev *Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, ev.ID+" "+ev.Params["Channel"])
+	}
+	client := connectWith(t, server, func(c *Client) {
+		c.RegisterHandler("Newchannel", handler)
+		c.RegisterHandler("Hangup", handler)
+	}, FilterHandledEvents, Filters(nil, []string{"Channel: Local/"}))
+	defer client.Close()
+
+	assert.Equal([]string{"Event: Hangup", "Event: Newchannel", "!Channel: Local/"}, filterActions(server), "filters not added")
+
+	server.Push(amitest.Frame{"Event": "Newchannel", "Channel": "SIP/trunk-1"})
+	server.Push(amitest.Frame{"Event": "VarSet", "Channel": "SIP/trunk-1"})
+	server.Push(amitest.Frame{"Event": "Newchannel", "Channel": "Local/100@default-1"})
+	server.Push(amitest.Frame{"Event": "Hangup", "Channel": "SIP/trunk-1"})
+	client.Ping()
+	client.Flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal([]string{"Newchannel SIP/trunk-1", "Hangup SIP/trunk-1"}, handled, "events not filtered by the server")
+	assert.Equal(map[string]EventStats{
+		"Newchannel": {Received: 1, Used: 1},
+		"Hangup":     {Received: 1, Used: 1},
+	}, client.GetEventStats(), "events not counted")
+}
+
+func TestFilterSubscribedOnceConnected(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	mutex := new(sync.Mutex)
+	handled := make([]string, 0)
+	handler := func(ev *Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, ev.ID)
+	}
+	client := connectWith(t, server, func(c *Client) {
+		c.RegisterHandler("Newchannel", handler)
+	}, FilterHandledEvents)
+	defer client.Close()
+
+	assert.Nil(client.RegisterHandler("Hangup", handler))
+	_, err := client.Subscribe("Queue*", handler)
+	assert.Nil(err)
+	_, err = client.Subscribe("Newchannel", handler)
+	assert.Nil(err)
+	client.Ping()
+	assert.Equal([]string{"Event: Newchannel", "Event: Hangup", "Event: Queue.*"}, filterActions(server),
+		"filters of the events subscribed once connected not added")
+
+	_, err = client.Subscribe("*", handler)
+	assert.Nil(err)
+	_, err = client.Subscribe("VarSet", handler)
+	assert.Nil(err)
+	client.Ping()
+	assert.Equal([]string{"Event: Newchannel", "Event: Hangup", "Event: Queue.*", allEventsFilter}, filterActions(server),
+		"a subscription to every event should add a filter matching every event")
+
+	server.Push(amitest.Frame{"Event": "Hangup"})
+	server.Push(amitest.Frame{"Event": "QueueMemberStatus"})
+	server.Push(amitest.Frame{"Event": "Newexten"})
+	client.Ping()
+	client.Flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal([]string{"Hangup", "Hangup", "QueueMemberStatus", "QueueMemberStatus", "Newexten"}, handled,
+		"events subscribed once connected not sent by the server")
+}
+
+func TestFilterNeedsEveryEvent(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	client := connectWith(t, server, func(c *Client) {
+		c.RegisterHandler("Newchannel", func(ev *Event) {})
+		c.RegisterDefaultHandler(func(ev *Event) {})
+	}, FilterHandledEvents)
+	defer client.Close()
+
+	assert.Empty(filterActions(server), "a default handler needs every event")
+}
+
+func TestAllowEvents(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestServer(t)
+	defer server.Close()
+
+	mutex := new(sync.Mutex)
+	handled := make([]string, 0)
+	client := connectWith(t, server, func(c *Client) {
+		c.RegisterDefaultHandler(func(ev *Event) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			handled = append(handled, ev.ID)
+		})
+	}, AllowEvents("Newchannel", "Hangup"))
+	defer client.Close()
+
+	for _, id := range []string{"Newchannel", "VarSet", "Newexten", "VarSet", "Hangup"} {
+		server.Push(amitest.Frame{"Event": id})
+	}
+	client.Ping()
+	client.Flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal([]string{"Newchannel", "Hangup"}, handled, "events not allowed should be discarded")
+	assert.Equal(EventStats{Received: 2, Filtered: 2}, client.GetEventStats()["VarSet"], "filtered events not counted")
+	assert.Equal(uint64(5), client.GetReceivedEventsCount(), "received events not counted")
+	assert.Equal(uint64(2), client.GetUsedEventsCount(), "used events not counted")
+}
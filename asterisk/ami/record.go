@@ -0,0 +1,158 @@
+package ami
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordTimeFormat timestamp format of the recorded frames
+const recordTimeFormat = time.RFC3339Nano
+
+// recorder write the frames received from AMI to a session file
+//
+// each frame is preceded by a "# <timestamp>" line and ends with its blank line:
+//
+//	# 2017-06-27T10:00:00.123456789+02:00
+//	Event: Newchannel
+//	Channel: SIP/trunk-00000001
+//	...
+//
+// the "Asterisk Call Manager" banner of each connection is not recorded
+type recorder struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	frame  bytes.Buffer
+	line   bytes.Buffer
+	banner bool
+}
+
+func newRecorder(w io.Writer) *recorder {
+	return &recorder{mutex: new(sync.Mutex), writer: w}
+}
+
+// reset start a new connection, dropping an incomplete frame of the previous one
+func (r *recorder) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.frame.Reset()
+	r.line.Reset()
+	r.banner = true
+}
+
+// feed add bytes read from the connection, writing each frame once complete
+func (r *recorder) feed(p []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.line.Write(p)
+			return nil
+		}
+		r.line.Write(p[:i+1])
+		p = p[i+1:]
+
+		line := r.line.Bytes()
+		if r.banner {
+			r.banner = false
+			r.line.Reset()
+			continue
+		}
+
+		empty := len(bytes.TrimRight(line, "\r\n")) == 0
+		r.frame.Write(line)
+		r.line.Reset()
+		if !empty {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(r.writer, "# %s\r\n", time.Now().Format(recordTimeFormat)); err != nil {
+			return err
+		}
+		if _, err := r.writer.Write(r.frame.Bytes()); err != nil {
+			return err
+		}
+		r.frame.Reset()
+	}
+	return nil
+}
+
+// recordingConn a connection copying what is read to a recorder
+type recordingConn struct {
+	io.ReadWriteCloser
+	recorder *recorder
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		if rerr := c.recorder.feed(p[:n]); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+// RecordTo return an option to record the frames received from AMI to w
+//
+// a recorded session can be fed back to the handlers with Client.Replay
+func RecordTo(w io.Writer) func(*Client) {
+	return func(c *Client) {
+		c.recorder = newRecorder(w)
+	}
+}
+
+// Replay read a recorded session and dispatch its frames to the registered handlers
+//
+// speed scales the delays between frames: 1 replays at real speed, 2 twice as fast,
+// 0 or less as fast as possible
+func (client *Client) Replay(r io.Reader, speed float64) error {
+	client.startDispatcher()
+	defer client.stopDispatcher()
+
+	reader := textproto.NewReader(bufio.NewReader(r))
+
+	var previous time.Time
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "# ") {
+			return fmt.Errorf("invalid frame timestamp line %q", line)
+		}
+
+		at, err := time.Parse(recordTimeFormat, strings.TrimPrefix(line, "# "))
+		if err != nil {
+			return err
+		}
+		if speed > 0 && !previous.IsZero() && at.After(previous) {
+			time.Sleep(time.Duration(float64(at.Sub(previous)) / speed))
+		}
+		previous = at
+
+		data, err := reader.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(data) > 0 {
+			client.dispatch(&data)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
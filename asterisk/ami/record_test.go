@@ -0,0 +1,55 @@
+package ami
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	session := "Asterisk Call Manager/2.10.3\r\n" +
+		"Response: Success\r\nActionID: login\r\nMessage: Authentication accepted\r\n\r\n" +
+		"Event: Newchannel\r\nPrivilege: call,all\r\nChannel: SIP/trunk-00000001\r\nUniqueid: 1.1\r\n\r\n" +
+		"Event: Hangup\r\nPrivilege: call,all\r\nChannel: SIP/trunk-00000001\r\nUniqueid: 1.1\r\nCause: 16\r\n\r\n"
+
+	var buffer bytes.Buffer
+	r := newRecorder(&buffer)
+	r.reset()
+	// frames are split across reads
+	for i := 0; i < len(session); i += 7 {
+		end := i + 7
+		if end > len(session) {
+			end = len(session)
+		}
+		assert.Nil(r.feed([]byte(session[i:end])))
+	}
+
+	recorded := buffer.String()
+	assert.Equal(3, strings.Count(recorded, "# "), "frames not correctly recorded")
+	assert.NotContains(recorded, "Asterisk Call Manager", "banner should not be recorded")
+	assert.Contains(recorded, "Event: Hangup\r\n", "frame not correctly recorded")
+
+	client := New("", "", "")
+	events := make([]*Event, 0)
+	client.RegisterHandler("Newchannel", func(ev *Event) { events = append(events, ev) })
+	client.RegisterHandler("Hangup", func(ev *Event) { events = append(events, ev) })
+
+	assert.Nil(client.Replay(strings.NewReader(recorded), 0))
+	assert.Equal(2, len(events), "events not correctly replayed")
+	assert.Equal("Newchannel", events[0].ID, "event not correctly replayed")
+	assert.Equal("1.1", events[0].Params["Uniqueid"], "event params not correctly replayed")
+	assert.Equal("16", events[1].Params["Cause"], "event params not correctly replayed")
+}
+
+func TestReplayInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	client := New("", "", "")
+	assert.NotNil(client.Replay(strings.NewReader("Event: Newchannel\r\n\r\n"), 0), "missing timestamp should fail")
+	assert.NotNil(client.Replay(strings.NewReader("# yesterday\r\nEvent: Newchannel\r\n\r\n"), 0), "invalid timestamp should fail")
+	assert.Nil(client.Replay(strings.NewReader(""), 0), "empty session should not fail")
+}
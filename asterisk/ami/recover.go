@@ -0,0 +1,53 @@
+package ami
+
+import (
+	"runtime/debug"
+
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+)
+
+// StrictHandlers option re-raising the panics of the handlers and middlewares, for tests
+//
+// by default a panic is logged with the event and the stack, counted, and the next events are handled
+func StrictHandlers(c *Client) {
+	c.strict = true
+}
+
+// OnPanic return an option calling f with the event and the recovered value when an handler panics
+func OnPanic(f func(ev *Event, recovered interface{})) func(*Client) {
+	return func(c *Client) {
+		c.onPanic = f
+	}
+}
+
+// protect call f with ev, recovering its panic unless the client is strict
+func (client *Client) protect(f func(*Event), ev *Event) {
+	if client.strict {
+		f(ev)
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			client.recovered(ev, r)
+		}
+	}()
+	f(ev)
+}
+
+// recovered log and count a panic raised while handling ev
+func (client *Client) recovered(ev *Event, r interface{}) {
+	logging.Error.Printf("panic handling %s event %v: %v\n%s", ev.ID, ev.Params, r, debug.Stack())
+	client.countEvent(ev, func(stats *EventStats) { stats.Panics++ })
+	if client.onPanic != nil {
+		client.onPanic(ev, r)
+	}
+}
+
+// GetPanicsCount return the number of panics recovered since the client was created
+func (client *Client) GetPanicsCount() uint64 {
+	var count uint64
+	for _, stats := range client.GetEventStats() {
+		count += stats.Panics
+	}
+	return count
+}
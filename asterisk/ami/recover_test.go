@@ -0,0 +1,55 @@
+package ami
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverHandlerPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	handled := make([]string, 0)
+	panics := make([]string, 0)
+	client := New("", "", "", OnPanic(func(ev *Event, recovered interface{}) {
+		panics = append(panics, ev.ID)
+	}))
+	client.Subscribe("*", func(ev *Event) {
+		if ev.ID == "Newstate" {
+			var params map[string]*string
+			_ = *params["Channelstatedesc"]
+		}
+	})
+	client.Subscribe("*", func(ev *Event) { handled = append(handled, ev.ID) })
+
+	client.startDispatcher()
+	for _, id := range []string{"Newchannel", "Newstate", "Hangup"} {
+		client.enqueue(&Event{ID: id})
+	}
+	client.stopDispatcher()
+
+	assert.Equal([]string{"Newchannel", "Newstate", "Hangup"}, handled, "events not handled after a panic")
+	assert.Equal([]string{"Newstate"}, panics, "panic not reported")
+	assert.Equal(uint64(1), client.GetEventStats()["Newstate"].Panics, "panic not counted")
+	assert.Equal(uint64(1), client.GetPanicsCount(), "panic not counted")
+}
+
+func TestRecoverMiddlewarePanics(t *testing.T) {
+	assert := assert.New(t)
+
+	client := New("", "", "")
+	client.Use(func(next func(*Event)) func(*Event) {
+		return func(ev *Event) { panic("middleware") }
+	})
+	assert.NotPanics(func() { client.enqueue(&Event{ID: "Newchannel"}) })
+	assert.Equal(uint64(1), client.GetPanicsCount(), "panic not counted")
+}
+
+func TestStrictHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	client := New("", "", "", StrictHandlers)
+	client.RegisterHandler("Newchannel", func(ev *Event) { panic("handler") })
+	assert.PanicsWithValue("handler", func() { client.enqueue(&Event{ID: "Newchannel"}) })
+	assert.Equal(uint64(0), client.GetPanicsCount(), "strict panics are not recovered")
+}
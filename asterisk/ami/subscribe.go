@@ -0,0 +1,139 @@
+package ami
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrBadPattern raised when a subscription pattern is not a valid glob
+var ErrBadPattern = errors.New("Bad subscription pattern")
+
+// Middleware wrap the dispatch of every event to the Events channel and the handlers,
+// next must be called for the event to be dispatched
+type Middleware func(next func(*Event)) func(*Event)
+
+// Subscription an handler subscribed to the events whose ID match a pattern
+type Subscription struct {
+	client  *Client
+	pattern string
+	literal bool
+	handler eventHandlerFunc
+}
+
+// Pattern return the pattern of the subscription
+func (s *Subscription) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe stop sending events to the handler
+func (s *Subscription) Unsubscribe() error {
+	client := s.client
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	if !client.removeSubscription(s) {
+		return errors.New("Handler not registered")
+	}
+	for id, sub := range client.handlers {
+		if sub == s {
+			delete(client.handlers, id)
+		}
+	}
+	return nil
+}
+
+// matches return true if the subscription pattern match an event ID
+func (s *Subscription) matches(id string) bool {
+	if s.literal {
+		return s.pattern == id
+	}
+	matched, _ := path.Match(s.pattern, id)
+	return matched
+}
+
+// Subscribe send the events whose ID match pattern to f
+//
+// pattern is an event ID or a glob, "Queue*" or "*" for example. The handlers subscribed to an event are called
+// in the order they subscribed, the default handler only receives the events no handler subscribed to
+func (client *Client) Subscribe(pattern string, f eventHandlerFunc) (*Subscription, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, ErrBadPattern
+	}
+	s := &Subscription{
+		client:  client,
+		pattern: pattern,
+		literal: !strings.ContainsAny(pattern, `*?[\`),
+		handler: f,
+	}
+
+	client.mutexObject.Lock()
+	client.subscriptions = append(client.subscriptions, s)
+	client.mutexObject.Unlock()
+	client.updateFilters()
+	return s, nil
+}
+
+// Use add middlewares wrapping the dispatch of every event, the first one added is the outermost
+func (client *Client) Use(middlewares ...Middleware) {
+	client.mutexObject.Lock()
+	defer client.mutexObject.Unlock()
+	client.middlewares = append(client.middlewares, middlewares...)
+
+	chain := client.deliver
+	for i := len(client.middlewares) - 1; i >= 0; i-- {
+		chain = client.middlewares[i](chain)
+	}
+	client.chain = chain
+}
+
+// removeSubscription remove s from the subscriptions, mutexObject must be held
+func (client *Client) removeSubscription(s *Subscription) bool {
+	for i, sub := range client.subscriptions {
+		if sub == s {
+			subscriptions := make([]*Subscription, 0, len(client.subscriptions)-1)
+			subscriptions = append(subscriptions, client.subscriptions[:i]...)
+			client.subscriptions = append(subscriptions, client.subscriptions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// subscribers return the handlers subscribed to an event ID
+func (client *Client) subscribers(id string) []eventHandlerFunc {
+	client.mutexObject.RLock()
+	defer client.mutexObject.RUnlock()
+	handlers := make([]eventHandlerFunc, 0, 1)
+	for _, s := range client.subscriptions {
+		if s.matches(id) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	return handlers
+}
+
+// subscribedEvents return the regular expressions matching the subscribed events text,
+// false if an event of any type may be subscribed
+func (client *Client) subscribedEvents() ([]string, bool) {
+	seen := make(map[string]bool)
+	filters := make([]string, 0, len(client.subscriptions))
+	for _, s := range client.subscriptions {
+		if s.pattern == "*" || strings.ContainsAny(s.pattern, `[\`) {
+			return nil, false
+		}
+		filter := "Event: " + globRegexp(s.pattern)
+		if !seen[filter] {
+			seen[filter] = true
+			filters = append(filters, filter)
+		}
+	}
+	return filters, true
+}
+
+// globRegexp convert a glob made of literals, * and ? into a regular expression
+func globRegexp(glob string) string {
+	re := regexp.QuoteMeta(glob)
+	re = strings.Replace(re, `\*`, `.*`, -1)
+	return strings.Replace(re, `\?`, `.`, -1)
+}
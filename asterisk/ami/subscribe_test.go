@@ -0,0 +1,92 @@
+package ami
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	handled := make([]string, 0)
+	record := func(name string) func(*Event) {
+		return func(ev *Event) { handled = append(handled, name+" "+ev.ID) }
+	}
+	client := New("", "", "")
+	client.RegisterDefaultHandler(record("default"))
+	client.RegisterHandler("QueueCallerJoin", record("handler"))
+	queue, err := client.Subscribe("Queue*", record("queue"))
+	assert.Nil(err)
+	client.Subscribe("QueueCallerJoin", record("join"))
+
+	for _, id := range []string{"QueueCallerJoin", "QueueMemberStatus", "Newchannel"} {
+		client.enqueue(&Event{ID: id})
+	}
+	assert.Equal([]string{
+		"handler QueueCallerJoin", "queue QueueCallerJoin", "join QueueCallerJoin",
+		"queue QueueMemberStatus",
+		"default Newchannel",
+	}, handled, "handlers not called in subscription order")
+
+	handled = handled[:0]
+	assert.Nil(queue.Unsubscribe())
+	assert.NotNil(queue.Unsubscribe(), "unsubscribed twice")
+	assert.Nil(client.UnregisterHandler("QueueCallerJoin"))
+	all, _ := client.Subscribe("*", record("all"))
+	client.enqueue(&Event{ID: "QueueCallerJoin"})
+	client.enqueue(&Event{ID: "QueueMemberStatus"})
+	assert.Equal([]string{"join QueueCallerJoin", "all QueueCallerJoin", "all QueueMemberStatus"}, handled, "handlers not unsubscribed")
+	assert.Equal("*", all.Pattern())
+
+	_, err = client.Subscribe("Queue[", record("bad"))
+	assert.Equal(ErrBadPattern, err)
+}
+
+func TestSubscribedEventsFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	client := New("", "", "", FilterHandledEvents)
+	client.RegisterHandler("Hangup", func(ev *Event) {})
+	client.Subscribe("Queue*", func(ev *Event) {})
+	client.Subscribe("Hangup", func(ev *Event) {})
+	include, _ := client.eventFilters()
+	assert.Equal([]string{"Event: Hangup", "Event: Queue.*"}, include, "globs not converted")
+
+	client.Subscribe("*", func(ev *Event) {})
+	include, _ = client.eventFilters()
+	assert.Empty(include, "* needs every event")
+}
+
+func TestMiddlewares(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := make([]string, 0)
+	trace := func(name string) Middleware {
+		return func(next func(*Event)) func(*Event) {
+			return func(ev *Event) {
+				calls = append(calls, name+" before")
+				next(ev)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+	client := New("", "", "")
+	client.RegisterHandler("Newchannel", func(ev *Event) { calls = append(calls, "handler") })
+	client.Use(trace("outer"), trace("inner"))
+	client.Use(func(next func(*Event)) func(*Event) {
+		return func(ev *Event) {
+			if ev.ID != "VarSet" {
+				next(ev)
+			}
+		}
+	})
+
+	client.enqueue(&Event{ID: "Newchannel"})
+	client.enqueue(&Event{ID: "VarSet"})
+	assert.Equal([]string{
+		"outer before", "inner before", "handler", "inner after", "outer after",
+		"outer before", "inner before", "inner after", "outer after",
+	}, calls, "middlewares not called in order")
+	assert.Equal(uint64(1), client.GetUsedEventsCount(), "events stopped by a middleware are not used")
+}
@@ -1,7 +1,6 @@
 package asterisk
 
 import (
-	"regexp"
 	"time"
 )
 
@@ -12,6 +11,7 @@ type Call struct {
 	UniqueID    string
 	Channel     string
 	Context     string
+	Direction   Direction
 
 	AccountCode string
 	CreatedAt   time.Time
@@ -22,8 +22,38 @@ type Call struct {
 	ActiveDuration int64
 	TotalDuration  int64
 
+	HeldAt       time.Time
+	HoldCount    int64
+	HoldDuration int64
+
 	HangupCause    string
 	HangupCauseTxt string
+
+	LongCall bool
+
+	Bridges []*Bridge
+	Quality Quality
+
+	TransferType        string
+	TransferTarget      string
+	TransferredAt       time.Time
+	TransferConnectedAt time.Time
+}
+
+// Bridge a period during which the call was in a bridge
+type Bridge struct {
+	ID        string
+	EnteredAt time.Time
+	LeftAt    time.Time
+}
+
+// Duration return the time spent in the bridge in ms, until now if the bridge was not left
+func (b *Bridge) Duration() int64 {
+	leftAt := b.LeftAt
+	if leftAt.IsZero() {
+		leftAt = time.Now()
+	}
+	return leftAt.Sub(b.EnteredAt).Nanoseconds() / int64(1000000)
 }
 
 // NewCall create a Call instance
@@ -34,6 +64,7 @@ func NewCall(source string, destination string, uniqueID string, channel string,
 		UniqueID:       uniqueID,
 		Channel:        channel,
 		Context:        context,
+		Direction:      DirectionUnknown,
 		CreatedAt:      time.Now(),
 		ActiveDuration: 0,
 		TotalDuration:  0,
@@ -42,16 +73,20 @@ func NewCall(source string, destination string, uniqueID string, channel string,
 	return &call
 }
 
-// GetTrunkName return the trunk of the call (remove SIP/ and channel id)
+// GetTrunkName return the trunk of the call resolved from its channel (see TrunkResolver)
 func (c *Call) GetTrunkName() string {
-	r, err := regexp.Compile("^(SIP|.*?)/(.*)\\-[0-9a-f]+$")
-	if err == nil {
-		if r.MatchString(c.Channel) {
-			values := r.FindAllStringSubmatch(c.Channel, -1)
-			return values[0][2]
-		}
-	}
-	return c.Channel
+	return GetTrunkResolver().Resolve(c.Channel)
+}
+
+// GetTechnology return the channel technology of the call (SIP, PJSIP, IAX2, ...)
+func (c *Call) GetTechnology() string {
+	technology, _ := splitChannel(c.Channel)
+	return technology
+}
+
+// Age return the time since the Call was created
+func (c *Call) Age() time.Duration {
+	return time.Since(c.CreatedAt)
 }
 
 // Answered mark the Call as answered
@@ -66,7 +101,7 @@ func (c *Call) Ringing() {
 
 // Busy mark the Call as Busy
 func (c *Call) Busy() {
-	c.HangupCause = "17";
+	c.HangupCause = "17"
 }
 
 // HangingUp mark the Call as HangingUp
@@ -99,6 +134,95 @@ func (c *Call) Hangup(cause string, causeTxt string) {
 	c.TotalDuration = time.Now().Sub(c.CreatedAt).Nanoseconds() / int64(1000000)
 }
 
+// Hold mark the Call as on hold, return false if it was already on hold
+func (c *Call) Hold() bool {
+	if c.IsOnHold() {
+		return false
+	}
+	c.HeldAt = time.Now()
+	c.HoldCount++
+	return true
+}
+
+// Unhold mark the Call as no more on hold, return false if it was not on hold
+func (c *Call) Unhold() bool {
+	if !c.IsOnHold() {
+		return false
+	}
+	c.HoldDuration += time.Now().Sub(c.HeldAt).Nanoseconds() / int64(1000000)
+	c.HeldAt = time.Time{}
+	return true
+}
+
+// IsOnHold return true if the Call is on hold
+func (c *Call) IsOnHold() bool {
+	return !c.HeldAt.IsZero()
+}
+
+// BridgeEnter mark the Call as entering the bridge bridgeID
+func (c *Call) BridgeEnter(bridgeID string) *Bridge {
+	bridge := &Bridge{
+		ID:        bridgeID,
+		EnteredAt: time.Now(),
+	}
+	c.Bridges = append(c.Bridges, bridge)
+	return bridge
+}
+
+// BridgeLeave mark the Call as leaving the bridge bridgeID, return nil if the call was not in that bridge
+func (c *Call) BridgeLeave(bridgeID string) *Bridge {
+	for i := len(c.Bridges) - 1; i >= 0; i-- {
+		bridge := c.Bridges[i]
+		if bridge.ID == bridgeID && bridge.LeftAt.IsZero() {
+			bridge.LeftAt = time.Now()
+			return bridge
+		}
+	}
+	return nil
+}
+
+// OpenBridges return the bridges the Call did not leave yet
+func (c *Call) OpenBridges() []*Bridge {
+	bridges := make([]*Bridge, 0)
+	for _, bridge := range c.Bridges {
+		if bridge.LeftAt.IsZero() {
+			bridges = append(bridges, bridge)
+		}
+	}
+	return bridges
+}
+
+// BridgedDuration return the total time spent in bridges in ms
+func (c *Call) BridgedDuration() int64 {
+	duration := int64(0)
+	for _, bridge := range c.Bridges {
+		duration += bridge.Duration()
+	}
+	return duration
+}
+
+// Transferred mark the Call as transferred
+//
+//	transferType: blind or attended
+//	target: extension@context for blind transfers, the target channel or application for attended ones
+func (c *Call) Transferred(transferType string, target string) {
+	c.TransferType = transferType
+	c.TransferTarget = target
+	c.TransferredAt = time.Now()
+}
+
+// TransferConnected mark the transferred Call as bridged with the transfer target
+func (c *Call) TransferConnected() {
+	if !c.TransferredAt.IsZero() && c.TransferConnectedAt.IsZero() {
+		c.TransferConnectedAt = time.Now()
+	}
+}
+
+// AbandonedInTransfer return true if the Call was transferred but never connected to the transfer target
+func (c *Call) AbandonedInTransfer() bool {
+	return !c.TransferredAt.IsZero() && c.TransferConnectedAt.IsZero()
+}
+
 // Disposition return the disposition
 func (c *Call) Disposition() string {
 	switch c.HangupCause {
@@ -174,3 +174,59 @@ func TestDispositionFailed(t *testing.T) {
 
 	assert.Equal("FAILED", call.Disposition(), "Wrong Disposition()")
 }
+
+func TestBridges(t *testing.T) {
+	assert := assert.New(t)
+	call := NewCall("source", "destination", "uniqueId", "SIP/Trunk-channel-1234deadbeef", "context")
+
+	call.BridgeEnter("bridge-1")
+	<-time.After(time.Millisecond * 50)
+	assert.Len(call.OpenBridges(), 1, "bridge-1 must be open")
+
+	assert.Nil(call.BridgeLeave("bridge-2"), "bridge-2 never entered")
+	bridge := call.BridgeLeave("bridge-1")
+	assert.NotNil(bridge, "bridge-1 not left")
+	assert.Len(call.OpenBridges(), 0, "bridge-1 must be closed")
+	assert.InDelta(int64(50), bridge.Duration(), 10, "Bridge duration not correctly set, expected ~50ms +-10ms")
+
+	call.BridgeEnter("bridge-2")
+	<-time.After(time.Millisecond * 20)
+	call.BridgeLeave("bridge-2")
+	assert.InDelta(int64(70), call.BridgedDuration(), 10, "BridgedDuration not correctly set, expected ~70ms +-10ms")
+}
+
+func TestTransfer(t *testing.T) {
+	assert := assert.New(t)
+	call := NewCall("source", "destination", "uniqueId", "SIP/Trunk-channel-1234deadbeef", "context")
+	assert.False(call.AbandonedInTransfer(), "call not transferred")
+
+	call.TransferConnected()
+	assert.True(call.TransferConnectedAt.IsZero(), "call not transferred must not be connected")
+
+	call.Transferred("blind", "100@context")
+	assert.Equal("blind", call.TransferType, "TransferType not correctly set")
+	assert.Equal("100@context", call.TransferTarget, "TransferTarget not correctly set")
+	assert.True(call.AbandonedInTransfer(), "transfer not connected yet")
+
+	call.TransferConnected()
+	assert.False(call.AbandonedInTransfer(), "transfer connected")
+}
+
+func TestHold(t *testing.T) {
+	assert := assert.New(t)
+	call := NewCall("source", "destination", "uniqueId", "SIP/Trunk-channel-1234deadbeef", "context")
+	assert.False(call.IsOnHold(), "call must not be on hold")
+	assert.False(call.Unhold(), "call not on hold can not be unhold")
+
+	assert.True(call.Hold(), "call not put on hold")
+	assert.False(call.Hold(), "call already on hold")
+	<-time.After(time.Millisecond * 50)
+	assert.True(call.Unhold(), "call not taken off hold")
+
+	call.Hold()
+	<-time.After(time.Millisecond * 20)
+	call.Unhold()
+
+	assert.Equal(int64(2), call.HoldCount, "HoldCount not correctly set")
+	assert.InDelta(int64(70), call.HoldDuration, 10, "HoldDuration not correctly set, expected ~70ms +-10ms")
+}
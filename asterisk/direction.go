@@ -0,0 +1,127 @@
+package asterisk
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Direction of a call
+type Direction string
+
+const (
+	// DirectionUnknown call direction could not be classified
+	DirectionUnknown Direction = "unknown"
+	// DirectionInbound call coming from a trunk
+	DirectionInbound Direction = "inbound"
+	// DirectionOutbound call going to a trunk
+	DirectionOutbound Direction = "outbound"
+	// DirectionInternal call between two local extensions
+	DirectionInternal Direction = "internal"
+)
+
+// DirectionRule classify a call when all its non empty patterns match
+//
+//	Context, Trunk, Source and Destination are regular expressions
+//	Technology is the channel technology (SIP, PJSIP, IAX2, DAHDI, ...)
+type DirectionRule struct {
+	Direction   Direction `json:"direction"`
+	Context     string    `json:"context,omitempty"`
+	Technology  string    `json:"technology,omitempty"`
+	Trunk       string    `json:"trunk,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+}
+
+type directionMatcher struct {
+	direction   Direction
+	technology  string
+	context     *regexp.Regexp
+	trunk       *regexp.Regexp
+	source      *regexp.Regexp
+	destination *regexp.Regexp
+}
+
+// DirectionClassifier classify calls with an ordered list of rules, the first matching rule wins
+type DirectionClassifier struct {
+	matchers []*directionMatcher
+}
+
+// DefaultDirectionRules used when no rule is configured
+//
+//	internal: short extension to short extension
+//	outbound: short extension to a long number
+//	inbound:  long number to anything
+var DefaultDirectionRules = []DirectionRule{
+	{Direction: DirectionInternal, Source: "^[0-9]{2,6}$", Destination: "^[0-9*#]{2,6}$"},
+	{Direction: DirectionOutbound, Source: "^[0-9]{2,6}$", Destination: "^\\+?[0-9]{7,}$"},
+	{Direction: DirectionInbound, Source: "^\\+?[0-9]{7,}$"},
+}
+
+// DefaultDirectionClassifier classifier built from DefaultDirectionRules
+var DefaultDirectionClassifier, _ = NewDirectionClassifier(DefaultDirectionRules)
+
+// NewDirectionClassifier compile rules into a DirectionClassifier
+func NewDirectionClassifier(rules []DirectionRule) (*DirectionClassifier, error) {
+	classifier := &DirectionClassifier{
+		matchers: make([]*directionMatcher, 0, len(rules)),
+	}
+
+	for _, rule := range rules {
+		m := &directionMatcher{
+			direction:  rule.Direction,
+			technology: strings.ToUpper(rule.Technology),
+		}
+
+		var err error
+		if m.context, err = compileOptional(rule.Context); err != nil {
+			return nil, err
+		}
+		if m.trunk, err = compileOptional(rule.Trunk); err != nil {
+			return nil, err
+		}
+		if m.source, err = compileOptional(rule.Source); err != nil {
+			return nil, err
+		}
+		if m.destination, err = compileOptional(rule.Destination); err != nil {
+			return nil, err
+		}
+		classifier.matchers = append(classifier.matchers, m)
+	}
+	return classifier, nil
+}
+
+// Classify return the Direction of the first matching rule, DirectionUnknown otherwise
+func (d *DirectionClassifier) Classify(call *Call) Direction {
+	for _, m := range d.matchers {
+		if m.match(call) {
+			return m.direction
+		}
+	}
+	return DirectionUnknown
+}
+
+func (m *directionMatcher) match(call *Call) bool {
+	if m.technology != "" && m.technology != strings.ToUpper(call.GetTechnology()) {
+		return false
+	}
+	if m.context != nil && !m.context.MatchString(call.Context) {
+		return false
+	}
+	if m.trunk != nil && !m.trunk.MatchString(call.GetTrunkName()) {
+		return false
+	}
+	if m.source != nil && !m.source.MatchString(call.Source) {
+		return false
+	}
+	if m.destination != nil && !m.destination.MatchString(call.Destination) {
+		return false
+	}
+	return true
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,46 @@
+package asterisk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultDirectionClassifier(t *testing.T) {
+	assert := assert.New(t)
+
+	call := NewCall("1001", "1002", "uniqueId", "SIP/1001-0000abcd", "from-internal")
+	assert.Equal(DirectionInternal, DefaultDirectionClassifier.Classify(call), "Wrong Direction")
+
+	call = NewCall("1001", "0033123456789", "uniqueId", "SIP/1001-0000abcd", "from-internal")
+	assert.Equal(DirectionOutbound, DefaultDirectionClassifier.Classify(call), "Wrong Direction")
+
+	call = NewCall("+33123456789", "1001", "uniqueId", "SIP/Trunk-0000abcd", "from-trunk")
+	assert.Equal(DirectionInbound, DefaultDirectionClassifier.Classify(call), "Wrong Direction")
+
+	call = NewCall("anonymous", "s", "uniqueId", "SIP/Trunk-0000abcd", "from-trunk")
+	assert.Equal(DirectionUnknown, DefaultDirectionClassifier.Classify(call), "Wrong Direction")
+}
+
+func TestDirectionClassifierRules(t *testing.T) {
+	assert := assert.New(t)
+
+	classifier, err := NewDirectionClassifier([]DirectionRule{
+		{Direction: DirectionInbound, Context: "^from-trunk"},
+		{Direction: DirectionOutbound, Technology: "sip", Trunk: "^Provider"},
+		{Direction: DirectionInternal},
+	})
+	assert.Nil(err, "rules must compile")
+
+	call := NewCall("anonymous", "s", "uniqueId", "SIP/Trunk-0000abcd", "from-trunk-provider")
+	assert.Equal(DirectionInbound, classifier.Classify(call), "Context rule not applied")
+
+	call = NewCall("1001", "s", "uniqueId", "SIP/Provider-0000abcd", "from-internal")
+	assert.Equal(DirectionOutbound, classifier.Classify(call), "Technology/Trunk rule not applied")
+
+	call = NewCall("1001", "s", "uniqueId", "IAX2/Provider-0000abcd", "from-internal")
+	assert.Equal(DirectionInternal, classifier.Classify(call), "Fallback rule not applied")
+
+	_, err = NewDirectionClassifier([]DirectionRule{{Direction: DirectionInbound, Source: "("}})
+	assert.NotNil(err, "invalid pattern must be rejected")
+}
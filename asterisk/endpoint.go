@@ -0,0 +1,55 @@
+package asterisk
+
+import (
+	"time"
+)
+
+// Endpoint define a SIP peer / PJSIP endpoint / IAX2 peer
+type Endpoint struct {
+	Technology string
+	Name       string
+
+	Registered  bool
+	Reachable   bool
+	DeviceState string
+
+	// RoundTrip qualify round trip time in ms, -1 if unknown
+	RoundTrip int64
+	UpdatedAt time.Time
+}
+
+// NewEndpoint create an Endpoint instance
+func NewEndpoint(technology string, name string) *Endpoint {
+	endpoint := Endpoint{
+		Technology: technology,
+		Name:       name,
+		RoundTrip:  -1,
+		UpdatedAt:  time.Now(),
+	}
+
+	return &endpoint
+}
+
+// NewEndpointFromDevice create an Endpoint from a device name (ex: PJSIP/provider)
+func NewEndpointFromDevice(device string) *Endpoint {
+	technology, name := splitChannel(device)
+	return NewEndpoint(technology, name)
+}
+
+// GetDevice return the device name of the Endpoint (ex: PJSIP/provider)
+func (e *Endpoint) GetDevice() string {
+	return e.Technology + "/" + e.Name
+}
+
+// GetTrunkName return the trunk of the Endpoint, like Call.GetTrunkName does for its channel
+func (e *Endpoint) GetTrunkName() string {
+	return GetTrunkResolver().ResolveEndpoint(e.Technology, e.Name)
+}
+
+// Update set the registration and reachability of the Endpoint
+func (e *Endpoint) Update(registered bool, reachable bool, roundTrip int64) {
+	e.Registered = registered
+	e.Reachable = reachable
+	e.RoundTrip = roundTrip
+	e.UpdatedAt = time.Now()
+}
@@ -0,0 +1,30 @@
+package asterisk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEndpointFromDevice(t *testing.T) {
+	assert := assert.New(t)
+	endpoint := NewEndpointFromDevice("PJSIP/provider")
+
+	assert.Equal("PJSIP", endpoint.Technology, "Technology not correctly set")
+	assert.Equal("provider", endpoint.Name, "Name not correctly set")
+	assert.Equal("PJSIP/provider", endpoint.GetDevice(), "Wrong GetDevice()")
+	assert.Equal("provider", endpoint.GetTrunkName(), "Wrong GetTrunkName()")
+	assert.Equal(int64(-1), endpoint.RoundTrip, "RoundTrip must be unknown")
+}
+
+func TestEndpointUpdate(t *testing.T) {
+	assert := assert.New(t)
+	endpoint := NewEndpoint("SIP", "provider")
+	updatedAt := endpoint.UpdatedAt
+
+	endpoint.Update(true, true, 12)
+	assert.True(endpoint.Registered, "Registered not correctly set")
+	assert.True(endpoint.Reachable, "Reachable not correctly set")
+	assert.Equal(int64(12), endpoint.RoundTrip, "RoundTrip not correctly set")
+	assert.False(endpoint.UpdatedAt.Before(updatedAt), "UpdatedAt not correctly set")
+}
@@ -0,0 +1,99 @@
+package asterisk
+
+// Quality RTP statistics of a call aggregated from RTCP reports
+type Quality struct {
+	Reports int64
+
+	// Jitter interarrival jitter in ms
+	JitterSum float64
+	JitterMax float64
+
+	// FractionLost percentage of packets lost since the previous report
+	FractionLostSum float64
+	CumulativeLost  int64
+
+	// RTT round trip time in ms
+	RTTSum   float64
+	RTTMax   float64
+	RTTCount int64
+}
+
+// RTCPReport a RTCP report block
+type RTCPReport struct {
+	// Jitter interarrival jitter in ms
+	Jitter float64
+	// FractionLost percentage of packets lost since the previous report
+	FractionLost float64
+	// CumulativeLost packets lost since the beginning of the stream
+	CumulativeLost int64
+	// RTT round trip time in ms, negative if unknown
+	RTT float64
+}
+
+// AddReport aggregate a RTCP report
+func (q *Quality) AddReport(report RTCPReport) {
+	q.Reports++
+	q.JitterSum += report.Jitter
+	if report.Jitter > q.JitterMax {
+		q.JitterMax = report.Jitter
+	}
+
+	q.FractionLostSum += report.FractionLost
+	if report.CumulativeLost > q.CumulativeLost {
+		q.CumulativeLost = report.CumulativeLost
+	}
+
+	if report.RTT >= 0 {
+		q.RTTCount++
+		q.RTTSum += report.RTT
+		if report.RTT > q.RTTMax {
+			q.RTTMax = report.RTT
+		}
+	}
+}
+
+// Jitter return the average jitter in ms
+func (q *Quality) Jitter() float64 {
+	if q.Reports == 0 {
+		return 0
+	}
+	return q.JitterSum / float64(q.Reports)
+}
+
+// Loss return the average packet loss percentage
+func (q *Quality) Loss() float64 {
+	if q.Reports == 0 {
+		return 0
+	}
+	return q.FractionLostSum / float64(q.Reports)
+}
+
+// RTT return the average round trip time in ms
+func (q *Quality) RTT() float64 {
+	if q.RTTCount == 0 {
+		return 0
+	}
+	return q.RTTSum / float64(q.RTTCount)
+}
+
+// MOS return the mean opinion score estimated with a simplified E-model (ITU-T G.107),
+// from 1 (bad) to 4.5 (excellent)
+func (q *Quality) MOS() float64 {
+	effectiveLatency := q.RTT()/2 + q.Jitter()*2 + 10
+
+	r := 93.2
+	if effectiveLatency < 160 {
+		r -= effectiveLatency / 40
+	} else {
+		r -= (effectiveLatency - 120) / 10
+	}
+	r -= q.Loss() * 2.5
+
+	if r < 0 {
+		return 1
+	}
+	if r > 100 {
+		r = 100
+	}
+	return 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
@@ -0,0 +1,37 @@
+package asterisk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQualityAddReport(t *testing.T) {
+	assert := assert.New(t)
+	quality := Quality{}
+	assert.Equal(float64(0), quality.Jitter(), "no report, no jitter")
+	assert.Equal(float64(0), quality.RTT(), "no report, no RTT")
+
+	quality.AddReport(RTCPReport{Jitter: 10, FractionLost: 1, CumulativeLost: 5, RTT: -1})
+	quality.AddReport(RTCPReport{Jitter: 30, FractionLost: 3, CumulativeLost: 12, RTT: 40})
+
+	assert.Equal(int64(2), quality.Reports, "Reports not correctly counted")
+	assert.Equal(float64(20), quality.Jitter(), "Wrong Jitter()")
+	assert.Equal(float64(30), quality.JitterMax, "JitterMax not correctly set")
+	assert.Equal(float64(2), quality.Loss(), "Wrong Loss()")
+	assert.Equal(int64(12), quality.CumulativeLost, "CumulativeLost not correctly set")
+	assert.Equal(float64(40), quality.RTT(), "Wrong RTT(), unknown RTT must be ignored")
+}
+
+func TestQualityMOS(t *testing.T) {
+	assert := assert.New(t)
+
+	perfect := Quality{}
+	perfect.AddReport(RTCPReport{RTT: 0})
+	assert.InDelta(4.4, perfect.MOS(), 0.05, "perfect call must be ~4.4")
+
+	bad := Quality{}
+	bad.AddReport(RTCPReport{Jitter: 80, FractionLost: 20, RTT: 600})
+	assert.Less(bad.MOS(), 2.0, "bad call must be under 2")
+	assert.GreaterOrEqual(bad.MOS(), 1.0, "MOS can not be under 1")
+}
@@ -0,0 +1,158 @@
+package asterisk
+
+import (
+	"time"
+)
+
+// Queue define an app_queue queue
+type Queue struct {
+	Name         string
+	ServiceLevel time.Duration
+
+	Callers map[string]*QueueCaller
+	Members map[string]*QueueMember
+
+	Answered               int64
+	AnsweredInServiceLevel int64
+	Abandoned              int64
+}
+
+// QueueCaller define a caller waiting in a Queue
+type QueueCaller struct {
+	UniqueID string
+	JoinedAt time.Time
+}
+
+// Queue member states
+const (
+	QueueMemberAvailable   = "available"
+	QueueMemberBusy        = "busy"
+	QueueMemberPaused      = "paused"
+	QueueMemberUnavailable = "unavailable"
+)
+
+// QueueMember define an agent of a Queue
+type QueueMember struct {
+	Interface    string
+	Name         string
+	Status       string
+	InCall       bool
+	Paused       bool
+	PausedReason string
+}
+
+// State return the member state: available, busy, paused or unavailable
+//
+//	Status is the AMI device state: 0 unknown, 1 not in use, 2 in use, 3 busy, 4 invalid,
+//	5 unavailable, 6 ringing, 7 ring in use, 8 on hold
+func (m *QueueMember) State() string {
+	if m.Paused {
+		return QueueMemberPaused
+	}
+	switch m.Status {
+	case "0", "1":
+		if m.InCall {
+			return QueueMemberBusy
+		}
+		return QueueMemberAvailable
+	case "2", "3", "6", "7", "8":
+		return QueueMemberBusy
+	}
+	return QueueMemberUnavailable
+}
+
+// NewQueue create a Queue instance
+func NewQueue(name string, serviceLevel time.Duration) *Queue {
+	queue := Queue{
+		Name:         name,
+		ServiceLevel: serviceLevel,
+		Callers:      make(map[string]*QueueCaller),
+		Members:      make(map[string]*QueueMember),
+	}
+
+	return &queue
+}
+
+// Join add a caller to the Queue
+func (q *Queue) Join(uniqueID string) *QueueCaller {
+	caller := &QueueCaller{
+		UniqueID: uniqueID,
+		JoinedAt: time.Now(),
+	}
+	q.Callers[uniqueID] = caller
+	return caller
+}
+
+// Leave remove a caller from the Queue, return nil if the caller was not waiting
+func (q *Queue) Leave(uniqueID string) *QueueCaller {
+	caller, found := q.Callers[uniqueID]
+	if !found {
+		return nil
+	}
+	delete(q.Callers, uniqueID)
+	return caller
+}
+
+// SetMember add or replace a member of the Queue
+func (q *Queue) SetMember(member *QueueMember) {
+	q.Members[member.Interface] = member
+}
+
+// RemoveMember remove a member from the Queue, return nil if it was not a member
+func (q *Queue) RemoveMember(iface string) *QueueMember {
+	member, found := q.Members[iface]
+	if !found {
+		return nil
+	}
+	delete(q.Members, iface)
+	return member
+}
+
+// MemberStates return the number of members per state, paused members are counted per pause reason
+//
+//	map[state][paused reason]count, the reason is empty for unpaused members
+func (q *Queue) MemberStates() map[string]map[string]int64 {
+	states := map[string]map[string]int64{
+		QueueMemberAvailable:   {"": 0},
+		QueueMemberBusy:        {"": 0},
+		QueueMemberPaused:      {},
+		QueueMemberUnavailable: {"": 0},
+	}
+	for _, member := range q.Members {
+		state := member.State()
+		reason := ""
+		if state == QueueMemberPaused {
+			reason = member.PausedReason
+		}
+		states[state][reason]++
+	}
+	return states
+}
+
+// Waiting return the number of callers waiting in the Queue
+func (q *Queue) Waiting() int64 {
+	return int64(len(q.Callers))
+}
+
+// Answer count a caller answered by an agent after waiting holdTime
+func (q *Queue) Answer(holdTime time.Duration) {
+	q.Answered++
+	if holdTime <= q.ServiceLevel {
+		q.AnsweredInServiceLevel++
+	}
+}
+
+// Abandon count a caller who hung up while waiting
+func (q *Queue) Abandon() {
+	q.Abandoned++
+}
+
+// ServiceLevelPercent return the percentage of callers answered within the service level
+// over all answered and abandoned callers, 100 if there was no caller
+func (q *Queue) ServiceLevelPercent() int64 {
+	total := q.Answered + q.Abandoned
+	if total == 0 {
+		return 100
+	}
+	return q.AnsweredInServiceLevel * 100 / total
+}
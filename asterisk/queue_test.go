@@ -0,0 +1,67 @@
+package asterisk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueCallers(t *testing.T) {
+	assert := assert.New(t)
+	queue := NewQueue("support", 20*time.Second)
+	assert.Equal(int64(0), queue.Waiting(), "no caller expected")
+
+	queue.Join("uniqueId-1")
+	queue.Join("uniqueId-2")
+	assert.Equal(int64(2), queue.Waiting(), "2 callers expected")
+
+	assert.NotNil(queue.Leave("uniqueId-1"), "uniqueId-1 not waiting")
+	assert.Nil(queue.Leave("uniqueId-1"), "uniqueId-1 already left")
+	assert.Equal(int64(1), queue.Waiting(), "1 caller expected")
+}
+
+func TestQueueServiceLevel(t *testing.T) {
+	assert := assert.New(t)
+	queue := NewQueue("support", 20*time.Second)
+	assert.Equal(int64(100), queue.ServiceLevelPercent(), "no caller must be 100%")
+
+	queue.Answer(5 * time.Second)
+	queue.Answer(20 * time.Second)
+	queue.Answer(30 * time.Second)
+	queue.Abandon()
+
+	assert.Equal(int64(3), queue.Answered, "Answered not correctly counted")
+	assert.Equal(int64(2), queue.AnsweredInServiceLevel, "AnsweredInServiceLevel not correctly counted")
+	assert.Equal(int64(1), queue.Abandoned, "Abandoned not correctly counted")
+	assert.Equal(int64(50), queue.ServiceLevelPercent(), "Wrong ServiceLevelPercent()")
+}
+
+func TestQueueMemberState(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(QueueMemberAvailable, (&QueueMember{Status: "1"}).State(), "not in use member must be available")
+	assert.Equal(QueueMemberBusy, (&QueueMember{Status: "1", InCall: true}).State(), "member in call must be busy")
+	assert.Equal(QueueMemberBusy, (&QueueMember{Status: "6"}).State(), "ringing member must be busy")
+	assert.Equal(QueueMemberUnavailable, (&QueueMember{Status: "5"}).State(), "unavailable member must be unavailable")
+	assert.Equal(QueueMemberPaused, (&QueueMember{Status: "1", Paused: true}).State(), "paused member must be paused")
+}
+
+func TestQueueMemberStates(t *testing.T) {
+	assert := assert.New(t)
+	queue := NewQueue("support", 20*time.Second)
+
+	queue.SetMember(&QueueMember{Interface: "SIP/1001", Status: "1"})
+	queue.SetMember(&QueueMember{Interface: "SIP/1002", Status: "2"})
+	queue.SetMember(&QueueMember{Interface: "SIP/1003", Status: "1", Paused: true, PausedReason: "lunch"})
+	queue.SetMember(&QueueMember{Interface: "SIP/1004", Status: "1", Paused: true, PausedReason: "lunch"})
+	queue.SetMember(&QueueMember{Interface: "SIP/1005", Status: "5"})
+	assert.NotNil(queue.RemoveMember("SIP/1005"), "SIP/1005 not a member")
+	assert.Nil(queue.RemoveMember("SIP/1005"), "SIP/1005 already removed")
+
+	states := queue.MemberStates()
+	assert.Equal(int64(1), states[QueueMemberAvailable][""], "wrong available count")
+	assert.Equal(int64(1), states[QueueMemberBusy][""], "wrong busy count")
+	assert.Equal(int64(2), states[QueueMemberPaused]["lunch"], "wrong paused count")
+	assert.Equal(int64(0), states[QueueMemberUnavailable][""], "wrong unavailable count")
+}
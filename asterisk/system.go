@@ -0,0 +1,76 @@
+package asterisk
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Version an Asterisk version
+type Version struct {
+	Raw   string
+	Major int
+	Minor int
+	Patch int
+}
+
+// System define the Asterisk server status
+type System struct {
+	Version  Version
+	MaxCalls int64
+
+	StartedAt    time.Time
+	ReloadedAt   time.Time
+	CurrentCalls int64
+	FullyBooted  bool
+}
+
+// 13.18.3, 16.2.1-rc1, certified/13.21-cert3, GIT-master-abcdef
+var regexpVersion = regexp.MustCompile("([0-9]+)\\.([0-9]+)(\\.([0-9]+))?")
+
+// ParseVersion parse an Asterisk version, unknown parts are set to 0
+func ParseVersion(raw string) Version {
+	version := Version{Raw: raw}
+	values := regexpVersion.FindStringSubmatch(raw)
+	if values == nil {
+		return version
+	}
+	version.Major, _ = strconv.Atoi(values[1])
+	version.Minor, _ = strconv.Atoi(values[2])
+	version.Patch, _ = strconv.Atoi(values[4])
+	return version
+}
+
+// IsKnown return true if the version was parsed
+func (v Version) IsKnown() bool {
+	return v.Major > 0
+}
+
+// AtLeast return true if the version is major.minor or later
+func (v Version) AtLeast(major int, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+// String return the raw version
+func (v Version) String() string {
+	return v.Raw
+}
+
+// Uptime return the time since Asterisk started, 0 if unknown
+func (s *System) Uptime() time.Duration {
+	if s.StartedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.StartedAt)
+}
+
+// SinceReload return the time since the last Asterisk reload, 0 if unknown
+func (s *System) SinceReload() time.Duration {
+	if s.ReloadedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.ReloadedAt)
+}
@@ -0,0 +1,45 @@
+package asterisk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	version := ParseVersion("13.18.3")
+	assert.Equal(13, version.Major, "Major not correctly parsed")
+	assert.Equal(18, version.Minor, "Minor not correctly parsed")
+	assert.Equal(3, version.Patch, "Patch not correctly parsed")
+	assert.Equal("13.18.3", version.String(), "Raw version must be kept")
+
+	version = ParseVersion("certified/13.21-cert3")
+	assert.Equal(13, version.Major, "Major not correctly parsed")
+	assert.Equal(21, version.Minor, "Minor not correctly parsed")
+	assert.Equal(0, version.Patch, "Patch not correctly parsed")
+
+	version = ParseVersion("GIT-master-abcdef")
+	assert.False(version.IsKnown(), "version must be unknown")
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert := assert.New(t)
+	version := ParseVersion("13.18.3")
+
+	assert.True(version.AtLeast(12, 0), "13.18 >= 12.0")
+	assert.True(version.AtLeast(13, 18), "13.18 >= 13.18")
+	assert.False(version.AtLeast(13, 19), "13.18 < 13.19")
+	assert.False(version.AtLeast(16, 0), "13.18 < 16.0")
+}
+
+func TestSystemUptime(t *testing.T) {
+	assert := assert.New(t)
+	system := &System{}
+	assert.Equal(time.Duration(0), system.Uptime(), "unknown Uptime must be 0")
+
+	system.StartedAt = time.Now().Add(-time.Hour)
+	assert.InDelta(float64(time.Hour), float64(system.Uptime()), float64(time.Second), "Wrong Uptime()")
+}
@@ -0,0 +1,202 @@
+package asterisk
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TrunkRule resolve a channel to a trunk name
+//
+// A rule with a Pattern is matched against the device of the channel or endpoint (ex: PJSIP/provider),
+// the whole channel name if its device cannot be extracted,
+// the trunk is the "trunk" named group, the first group or Trunk expanded with the groups ($1, ${name}).
+// A rule with a Lookup maps the endpoint / peer name (ex: provider) to a trunk name.
+// Technology restricts the rule to a channel technology (SIP, PJSIP, IAX2, DAHDI, Local, ...).
+type TrunkRule struct {
+	Technology string            `json:"technology,omitempty"`
+	Pattern    string            `json:"pattern,omitempty"`
+	Trunk      string            `json:"trunk,omitempty"`
+	Lookup     map[string]string `json:"lookup,omitempty"`
+}
+
+type trunkMatcher struct {
+	technology string
+	pattern    *regexp.Regexp
+	trunk      string
+	lookup     map[string]string
+}
+
+// TrunkResolver resolve channels and endpoints to trunk names
+type TrunkResolver struct {
+	patterns []*trunkMatcher
+	lookups  []*trunkMatcher
+}
+
+var (
+	// SIP/provider-0000abcd, PJSIP/provider-00000001, IAX2/provider-1234
+	regexpPeerChannel = regexp.MustCompile("^(.*)\\-[0-9a-f]+$")
+	// DAHDI/1-1, DAHDI/i1/0123456789-1
+	regexpDAHDIChannel = regexp.MustCompile("^(i?[0-9]+|pseudo)[/\\-]")
+	// Local/100@context-00000001;1
+	regexpLocalChannel = regexp.MustCompile("^(.*@.*?)\\-[0-9a-f]+(;[0-9]+)?$")
+)
+
+var trunkResolverMutex = new(sync.RWMutex)
+var trunkResolver, _ = NewTrunkResolver(nil)
+
+// SetTrunkResolver set the resolver used by Call.GetTrunkName
+func SetTrunkResolver(resolver *TrunkResolver) {
+	trunkResolverMutex.Lock()
+	defer trunkResolverMutex.Unlock()
+	trunkResolver = resolver
+}
+
+// GetTrunkResolver return the resolver used by Call.GetTrunkName
+func GetTrunkResolver() *TrunkResolver {
+	trunkResolverMutex.RLock()
+	defer trunkResolverMutex.RUnlock()
+	return trunkResolver
+}
+
+// NewTrunkResolver compile rules into a TrunkResolver
+func NewTrunkResolver(rules []TrunkRule) (*TrunkResolver, error) {
+	resolver := &TrunkResolver{
+		patterns: make([]*trunkMatcher, 0),
+		lookups:  make([]*trunkMatcher, 0),
+	}
+
+	for _, rule := range rules {
+		m := &trunkMatcher{
+			technology: strings.ToUpper(rule.Technology),
+			trunk:      rule.Trunk,
+			lookup:     rule.Lookup,
+		}
+
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			m.pattern = pattern
+			resolver.patterns = append(resolver.patterns, m)
+		}
+
+		if len(rule.Lookup) > 0 {
+			resolver.lookups = append(resolver.lookups, m)
+		}
+	}
+	return resolver, nil
+}
+
+// Resolve return the trunk name of a channel, the channel itself if it cannot be resolved
+func (r *TrunkResolver) Resolve(channel string) string {
+	technology, resource := splitChannel(channel)
+
+	name, ok := endpointFromChannel(technology, resource)
+	if !ok {
+		if trunk, ok := r.matchPattern(technology, channel); ok {
+			return trunk
+		}
+		return channel
+	}
+
+	if trunk, ok := r.matchPattern(technology, deviceName(technology, name)); ok {
+		return trunk
+	}
+
+	if trunk, ok := r.matchLookup(technology, name); ok {
+		return trunk
+	}
+	return name
+}
+
+// ResolveEndpoint return the trunk name of an endpoint / peer, the endpoint itself if it cannot be resolved,
+// an endpoint and the channels it creates resolve to the same trunk
+func (r *TrunkResolver) ResolveEndpoint(technology string, endpoint string) string {
+	if trunk, ok := r.matchPattern(technology, deviceName(technology, endpoint)); ok {
+		return trunk
+	}
+
+	if trunk, ok := r.matchLookup(technology, endpoint); ok {
+		return trunk
+	}
+	return endpoint
+}
+
+func (r *TrunkResolver) matchPattern(technology string, channel string) (string, bool) {
+	technology = strings.ToUpper(technology)
+	for _, m := range r.patterns {
+		if m.technology != "" && m.technology != technology {
+			continue
+		}
+
+		submatches := m.pattern.FindStringSubmatchIndex(channel)
+		if submatches == nil {
+			continue
+		}
+
+		if m.trunk != "" {
+			return string(m.pattern.ExpandString(nil, m.trunk, channel, submatches)), true
+		}
+		if i := m.pattern.SubexpIndex("trunk"); i > 0 {
+			return channel[submatches[2*i]:submatches[2*i+1]], true
+		}
+		if m.pattern.NumSubexp() > 0 && submatches[2] >= 0 {
+			return channel[submatches[2]:submatches[3]], true
+		}
+		return channel, true
+	}
+	return "", false
+}
+
+func (r *TrunkResolver) matchLookup(technology string, name string) (string, bool) {
+	technology = strings.ToUpper(technology)
+	for _, m := range r.lookups {
+		if m.technology != "" && m.technology != technology {
+			continue
+		}
+		if trunk, ok := m.lookup[name]; ok {
+			return trunk, true
+		}
+	}
+	return "", false
+}
+
+// deviceName return the device of an endpoint, DAHDI and Local endpoints already carry their technology
+func deviceName(technology string, endpoint string) string {
+	if strings.Contains(endpoint, "/") {
+		return endpoint
+	}
+	return technology + "/" + endpoint
+}
+
+func splitChannel(channel string) (string, string) {
+	if i := strings.Index(channel, "/"); i > 0 {
+		return channel[:i], channel[i+1:]
+	}
+	return "", channel
+}
+
+// endpointFromChannel extract the endpoint name from the channel resource depending on the technology
+func endpointFromChannel(technology string, resource string) (string, bool) {
+	switch strings.ToUpper(technology) {
+	case "":
+		return "", false
+	case "DAHDI":
+		if values := regexpDAHDIChannel.FindStringSubmatch(resource); values != nil {
+			return technology + "/" + values[1], true
+		}
+		return "", false
+	case "LOCAL":
+		if values := regexpLocalChannel.FindStringSubmatch(resource); values != nil {
+			return technology + "/" + values[1], true
+		}
+		return "", false
+	default:
+		if values := regexpPeerChannel.FindStringSubmatch(resource); values != nil {
+			return values[1], true
+		}
+		return "", false
+	}
+}
@@ -0,0 +1,56 @@
+package asterisk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrunkResolverTechnologies(t *testing.T) {
+	assert := assert.New(t)
+	resolver, err := NewTrunkResolver(nil)
+	assert.Nil(err, "empty rules must compile")
+
+	assert.Equal("Trunk-channel", resolver.Resolve("SIP/Trunk-channel-1234deadbeef"), "SIP trunk not extracted")
+	assert.Equal("provider", resolver.Resolve("PJSIP/provider-00000001"), "PJSIP trunk not extracted")
+	assert.Equal("provider", resolver.Resolve("IAX2/provider-1234"), "IAX2 trunk not extracted")
+	assert.Equal("DAHDI/1", resolver.Resolve("DAHDI/1-1"), "DAHDI trunk not extracted")
+	assert.Equal("DAHDI/i1", resolver.Resolve("DAHDI/i1/0123456789-1"), "DAHDI ISDN trunk not extracted")
+	assert.Equal("Local/100@ctx", resolver.Resolve("Local/100@ctx-0000;1"), "Local trunk not extracted")
+	assert.Equal("SIP/channel", resolver.Resolve("SIP/channel"), "unknown channel must be kept")
+	assert.Equal("channel", resolver.Resolve("channel"), "unknown channel must be kept")
+}
+
+func TestTrunkResolverRules(t *testing.T) {
+	assert := assert.New(t)
+	resolver, err := NewTrunkResolver([]TrunkRule{
+		{Technology: "PJSIP", Lookup: map[string]string{"provider-a": "provider", "provider-b": "provider"}},
+		{Technology: "SIP", Pattern: "^SIP/(?P<trunk>gw[0-9]+)\\.example\\.com$"},
+		{Pattern: "^IAX2/([a-z]+)[0-9]*$", Trunk: "iax-$1"},
+		{Pattern: "^weird$", Trunk: "unparsed"},
+	})
+	assert.Nil(err, "rules must compile")
+
+	assert.Equal("provider", resolver.Resolve("PJSIP/provider-b-00000001"), "lookup rule not applied")
+	assert.Equal("other", resolver.Resolve("PJSIP/other-00000001"), "builtin extraction not applied")
+	assert.Equal("gw1", resolver.Resolve("SIP/gw1.example.com-0000abcd"), "named group not applied")
+	assert.Equal("iax-peer", resolver.Resolve("IAX2/peer12-1234"), "trunk template not applied")
+	assert.Equal("provider", resolver.ResolveEndpoint("PJSIP", "provider-a"), "endpoint lookup not applied")
+	assert.Equal("gw2", resolver.ResolveEndpoint("SIP", "gw2.example.com"), "endpoint pattern not applied")
+	assert.Equal("gw3.example.org", resolver.ResolveEndpoint("SIP", "gw3.example.org"), "endpoint must be kept")
+	assert.Equal("unparsed", resolver.Resolve("weird"), "channel without device not matched")
+
+	for channel, endpoint := range map[string][2]string{
+		"PJSIP/provider-b-00000001":    {"PJSIP", "provider-b"},
+		"PJSIP/other-00000001":         {"PJSIP", "other"},
+		"SIP/gw1.example.com-0000abcd": {"SIP", "gw1.example.com"},
+		"IAX2/peer12-1234":             {"IAX2", "peer12"},
+		"DAHDI/i1/0123456789-1":        {"DAHDI", "DAHDI/i1"},
+	} {
+		assert.Equal(resolver.Resolve(channel), resolver.ResolveEndpoint(endpoint[0], endpoint[1]),
+			"channel "+channel+" and its endpoint resolve to different trunks")
+	}
+
+	_, err = NewTrunkResolver([]TrunkRule{{Pattern: "("}})
+	assert.NotNil(err, "invalid pattern must be rejected")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/alerting"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/statsd-ami"
+)
+
+// Config content of the -config json file
+type Config struct {
+	// Directions rules used to classify calls, first matching rule wins
+	Directions []asterisk.DirectionRule `json:"directions"`
+
+	// Trunks rules used to resolve trunk names from channels
+	Trunks []asterisk.TrunkRule `json:"trunks"`
+
+	// Queues app_queue settings
+	Queues QueuesConfig `json:"queues"`
+
+	// Sweeper long running calls detection
+	Sweeper SweeperConfig `json:"sweeper"`
+
+	// Alerting rules and notifiers
+	Alerting AlertingConfig `json:"alerting"`
+
+	// Fraud toll fraud detectors
+	Fraud *statsdami.FraudRules `json:"fraud"`
+
+	// Metrics shape of the metrics by default name, "*" for all of them
+	Metrics map[string]statsdami.MetricConfig `json:"metrics"`
+
+	// Cardinality limits of the tag values
+	Cardinality map[string]statsdami.TagLimit `json:"cardinality"`
+
+	// Rollups aggregations of the metrics by default name, "*" for the metrics not listed
+	Rollups map[string]statsdami.RollupConfig `json:"rollups"`
+}
+
+// AlertingConfig alerting rules and notifiers
+type AlertingConfig struct {
+	// Interval seconds between two evaluations of the rules
+	Interval int `json:"interval"`
+
+	Rules    []alerting.Rule             `json:"rules"`
+	Webhooks []*alerting.WebhookNotifier `json:"webhooks"`
+	Commands []*alerting.ExecNotifier    `json:"commands"`
+}
+
+// SweeperConfig long running calls detection settings
+type SweeperConfig struct {
+	// Interval seconds between two sweeps
+	Interval int `json:"interval"`
+
+	// MaxDuration seconds after which a call is considered as long running
+	MaxDuration int `json:"max_duration"`
+
+	// MaxDurations per trunk maximum durations in seconds
+	MaxDurations map[string]int `json:"max_durations"`
+}
+
+// QueuesConfig app_queue settings
+type QueuesConfig struct {
+	// ServiceLevel seconds within which a caller should be answered
+	ServiceLevel int `json:"service_level"`
+
+	// ServiceLevels per queue service levels in seconds
+	ServiceLevels map[string]int `json:"service_levels"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// sweeperInterval return the interval between two sweeps, 1 minute by default
+func (c *Config) sweeperInterval() time.Duration {
+	if c.Sweeper.Interval > 0 {
+		return time.Duration(c.Sweeper.Interval) * time.Second
+	}
+	return time.Minute
+}
+
+// alertingInterval return the interval between two evaluations of the alerting rules, 10 seconds by default
+func (c *Config) alertingInterval() time.Duration {
+	if c.Alerting.Interval > 0 {
+		return time.Duration(c.Alerting.Interval) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// newAlertingEngine create the alerting engine, nil if no rule nor fraud detector is configured
+func (c *Config) newAlertingEngine() (*alerting.Engine, error) {
+	if len(c.Alerting.Rules) == 0 && c.Fraud == nil {
+		return nil, nil
+	}
+
+	notifiers := make([]alerting.Notifier, 0)
+	for _, webhook := range c.Alerting.Webhooks {
+		notifiers = append(notifiers, webhook)
+	}
+	for _, command := range c.Alerting.Commands {
+		notifiers = append(notifiers, command)
+	}
+
+	engine, err := alerting.NewEngine(c.Alerting.Rules, notifiers)
+	if err != nil {
+		return nil, err
+	}
+	for metric, source := range statsdami.AlertSources() {
+		engine.RegisterSource(metric, source)
+	}
+	return engine, nil
+}
+
+// applyFraudConfig enable the fraud detector if configured
+func (c *Config) applyFraudConfig(engine *alerting.Engine, amiClient *ami.Client) error {
+	if c.Fraud == nil {
+		return nil
+	}
+	detector, err := statsdami.NewFraudDetector(*c.Fraud, engine, amiClient)
+	if err != nil {
+		return err
+	}
+	statsdami.SetFraudDetector(detector)
+	return nil
+}
+
+func applyConfig(config *Config) error {
+	if len(config.Trunks) > 0 {
+		resolver, err := asterisk.NewTrunkResolver(config.Trunks)
+		if err != nil {
+			return err
+		}
+		asterisk.SetTrunkResolver(resolver)
+	}
+
+	if config.Queues.ServiceLevel > 0 || len(config.Queues.ServiceLevels) > 0 {
+		serviceLevel := statsdami.DefaultServiceLevel
+		if config.Queues.ServiceLevel > 0 {
+			serviceLevel = time.Duration(config.Queues.ServiceLevel) * time.Second
+		}
+		perQueue := make(map[string]time.Duration)
+		for name, seconds := range config.Queues.ServiceLevels {
+			perQueue[name] = time.Duration(seconds) * time.Second
+		}
+		statsdami.SetQueueServiceLevels(serviceLevel, perQueue)
+	}
+
+	if config.Sweeper.MaxDuration > 0 || len(config.Sweeper.MaxDurations) > 0 {
+		maxDuration := statsdami.DefaultMaxCallDuration
+		if config.Sweeper.MaxDuration > 0 {
+			maxDuration = time.Duration(config.Sweeper.MaxDuration) * time.Second
+		}
+		perTrunk := make(map[string]time.Duration)
+		for trunk, seconds := range config.Sweeper.MaxDurations {
+			perTrunk[trunk] = time.Duration(seconds) * time.Second
+		}
+		statsdami.SetMaxCallDurations(maxDuration, perTrunk)
+	}
+
+	if len(config.Directions) > 0 {
+		classifier, err := asterisk.NewDirectionClassifier(config.Directions)
+		if err != nil {
+			return err
+		}
+		statsdami.SetDirectionClassifier(classifier)
+	}
+
+	if len(config.Metrics) > 0 {
+		catalogue, err := statsdami.NewMetricCatalogue(config.Metrics)
+		if err != nil {
+			return err
+		}
+		statsdami.SetMetricCatalogue(catalogue)
+	}
+
+	if len(config.Cardinality) > 0 {
+		limits := make(map[string]statsdami.TagLimit)
+		for tag, limit := range statsdami.DefaultTagLimits {
+			limits[tag] = limit
+		}
+		for tag, limit := range config.Cardinality {
+			limits[tag] = limit
+		}
+		limiter, err := statsdami.NewCardinalityLimiter(limits)
+		if err != nil {
+			return err
+		}
+		statsdami.SetCardinalityLimiter(limiter)
+	}
+
+	if len(config.Rollups) > 0 {
+		if err := statsdami.SetRollups(config.Rollups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
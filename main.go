@@ -7,6 +7,7 @@ import (
 	"flag"
 	"os/signal"
 	"regexp"
+	"runtime"
 	"sync"
 	"syscall"
 	"time"
@@ -19,8 +20,8 @@ import (
 )
 
 var (
-	Version   string
-  Build string
+	Version string
+	Build   string
 )
 
 type eventHandler func(*statsd.StatsdClient, *ami.Event, map[string]string)
@@ -28,6 +29,9 @@ type eventHandler func(*statsd.StatsdClient, *ami.Event, map[string]string)
 var stopMutex = new(sync.RWMutex)
 var stopValue = false
 
+var linkMutex = new(sync.RWMutex)
+var linkDownSince = time.Now()
+
 func main() {
 
 	// logging.Init(logging.Trace, os.Stdout)
@@ -36,32 +40,44 @@ func main() {
 	logging.InitWithSyslog(logging.Warning, os.Stdout, "asterisk-monitor")
 	logging.InitWithSyslog(logging.Error, os.Stdout, "asterisk-monitor")
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "simulate":
+			simulate(os.Args[2:])
+			return
+		}
+	}
+
 	asteriskInfo := flag.String("asterisk", "", "asterisk connection info. format: user:password@host:port")
 	statsdInfo := flag.String("statsd", "", "statsd connection info. format: host:port/prefix")
+	configFile := flag.String("config", "", "json configuration file")
+	systemInterval := flag.Duration("system-interval", time.Second*10, "interval between CoreSettings / CoreStatus polls")
+	kpiInterval := flag.Duration("kpi-interval", time.Second*10, "interval between ASR / ACD / NER publications")
+	endpointsInterval := flag.Duration("endpoints-interval", time.Minute, "interval between SIP peers / PJSIP endpoints polls")
+	recordFile := flag.String("record", "", "record the AMI session to a file which can be replayed")
+	queueSize := flag.Int("dispatch-queue", ami.DefaultDispatchQueueSize, "events received but not yet handled before -overflow applies")
+	overflow := flag.String("overflow", "block", "when the dispatch queue is full: block, drop-oldest or drop-newest")
+	workers := flag.Int("workers", runtime.NumCPU(), "goroutines handling the events")
+	shardKey := flag.String("shard-key", "uniqueid", "events with the same key are handled in order: linkedid or uniqueid")
+	selfInterval := flag.Duration("self-interval", time.Second*10, "interval between publications of the metrics about the monitor itself, 0 to disable")
+	selfPrefix := flag.String("self-prefix", statsdami.DefaultSelfPrefix, "prefix of the metrics about the monitor itself")
+	filterEvents := flag.Bool("filter-events", true, "ask Asterisk to send only the events having a handler")
+	filterExclude := flag.String("filter-exclude", "", "regular expression of the events Asterisk should not send")
 	flag.Parse()
 
-	statsdEnabled := true
-	regexStatsd, _ := regexp.Compile("^(.+?)(:(.*?))?(/(.*?))?$")
-	if !regexStatsd.MatchString(*statsdInfo) {
-		logging.Error.Println("statsd disabled")
-		statsdEnabled = false
+	config, err := loadConfig(*configFile)
+	if err == nil {
+		err = applyConfig(config)
 	}
-
-	var statsdclient *statsd.Statsd
-	if statsdEnabled {
-
-		matches := regexStatsd.FindAllStringSubmatch(*statsdInfo, -1)
-		statsdHost := matches[0][1] + ":" + matches[0][3]
-		statsdPrefix := matches[0][5]
-
-		client := statsd.Statsd(statsd.NewStatsdClient(statsdHost, statsdPrefix))
-		statsdclient = &client
-	} else {
-		client := statsd.Statsd(statsd.NoopClient{})
-		statsdclient = &client
+	if err != nil {
+		logging.Error.Println("could not load configuration <"+(*configFile)+">:", err)
+		os.Exit(1)
 	}
 
-	err := (*statsdclient).CreateSocket()
+	statsdclient, err := newStatsdClient(*statsdInfo)
 	if nil != err {
 		logging.Error.Println(err)
 		os.Exit(1)
@@ -78,12 +94,71 @@ func main() {
 	asteriskPassword := matches[0][2]
 	asteriskAddress := matches[0][3]
 
-	amiClient := ami.New(asteriskAddress, asteriskUsername, asteriskPassword)
+	policy, err := ami.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+	shardBy, err := ami.ParseShardKey(*shardKey)
+	if err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+	options := []func(*ami.Client){
+		ami.DispatchQueue(*queueSize, policy),
+		ami.DispatchWorkers(*workers),
+		ami.ShardBy(shardBy),
+		ami.OnPanic(statsdami.NewPanicCounter(statsdclient)),
+	}
+	if *filterEvents {
+		options = append(options, ami.FilterHandledEvents)
+	}
+	if *filterExclude != "" {
+		if _, err := regexp.Compile(*filterExclude); err != nil {
+			logging.Error.Println("could not parse -filter-exclude:", err)
+			os.Exit(1)
+		}
+		options = append(options, ami.Filters(nil, []string{*filterExclude}))
+	}
+	if *recordFile != "" {
+		file, err := os.OpenFile(*recordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			logging.Error.Println("could not open record file <"+(*recordFile)+">:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		options = append(options, ami.RecordTo(file))
+	}
+
+	amiClient := ami.New(asteriskAddress, asteriskUsername, asteriskPassword, options...)
+	if *selfInterval > 0 {
+		statsdami.SetSelfPrefix(*selfPrefix)
+		amiClient.Use(statsdami.HandlerLatency)
+		statsdami.NewPoller(*selfInterval, func() {
+			statsdami.PublishSelf(statsdclient, amiClient)
+		})
+	}
 
-	amiClient.RegisterHandler("Newchannel", statsdami.NewHandler(statsdclient, statsdami.EventNewChannelHandler))
-	amiClient.RegisterHandler("Newstate", statsdami.NewHandler(statsdclient, statsdami.EventNewStateHandler))
-	amiClient.RegisterHandler("SoftHangupRequest", statsdami.NewHandler(statsdclient, statsdami.EventSoftHangupHandler))
-	amiClient.RegisterHandler("Hangup", statsdami.NewHandler(statsdclient, statsdami.EventHangupHandler))
+	engine, err := config.newAlertingEngine()
+	if err != nil {
+		logging.Error.Println("could not create alerting engine:", err)
+		os.Exit(1)
+	}
+	statsdami.NewPoller(*kpiInterval, func() {
+		statsdami.PublishKPIs(statsdclient)
+	})
+
+	if err := config.applyFraudConfig(engine, amiClient); err != nil {
+		logging.Error.Println("could not create fraud detector:", err)
+		os.Exit(1)
+	}
+
+	if engine != nil {
+		engine.RegisterSource("ami_down", linkDownSource)
+		statsdami.NewPoller(config.alertingInterval(), engine.Evaluate)
+	}
+
+	registerHandlers(amiClient, statsdclient)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
@@ -107,7 +182,14 @@ func main() {
 				{
 					logging.Debug.Println("Pending calls:", statsdami.GetPendingCallsCount())
 					logging.Debug.Println("Pending responses:", amiClient.GetPendingActionsCount())
+					logging.Debug.Println("Queued events:", amiClient.GetQueuedEventsCount())
+					logging.Debug.Println("Received events:", amiClient.GetReceivedEventsCount())
+					logging.Debug.Println("Used events:", amiClient.GetUsedEventsCount())
+					logging.Debug.Println("Dropped events:", amiClient.GetDroppedEventsCount())
+					logging.Debug.Println("Handler panics:", amiClient.GetPanicsCount())
 					logging.Debug.Println("Gauges:", statsdami.GetGaugeCount())
+					logging.Debug.Println("Queues:", statsdami.GetQueuesCount())
+					logging.Debug.Println("Endpoints:", statsdami.GetEndpointsCount())
 				}
 			case syscall.SIGUSR2:
 				{
@@ -118,8 +200,14 @@ func main() {
 					logging.Init(logging.Dump, file)
 					logging.Dump.Println("-----------")
 					ami.Dump(amiClient, logging.Dump)
+					ami.DumpEventStats(amiClient, logging.Dump)
 					statsdami.Dump(logging.Dump)
 					statsdami.DumpGauges(logging.Dump)
+					statsdami.DumpCardinality(logging.Dump)
+					statsdami.DumpQueues(logging.Dump)
+					statsdami.DumpEndpoints(logging.Dump)
+					statsdami.DumpSystem(logging.Dump)
+					statsdami.DumpHistory(logging.Dump)
 					file.Close()
 				}
 			}
@@ -129,15 +217,38 @@ func main() {
 	for !shouldStop() {
 		amiClient.StopKeepAlive()
 
-		if err := amiClient.Connect(map[string]string{"Events": "call,command"}); err != nil {
+		if err := amiClient.Connect(map[string]string{"Events": "call,command,agent,system,reporting"}); err != nil {
 			logging.Error.Println(err)
 		} else {
 			logging.Info.Println("Connected to", asteriskAddress)
+			statsdami.CountConnection()
+			setLinkUp(true)
 			amiClient.KeepAlive(time.Second * 1)
 
+			go func() {
+				if err := statsdami.SeedQueues(statsdclient, amiClient, time.Second*30); err != nil {
+					logging.Error.Println("could not load queues status:", err)
+				}
+			}()
+
+			systemPoller := statsdami.NewPoller(*systemInterval, func() {
+				statsdami.PollSystem(statsdclient, amiClient, time.Second*30)
+			})
+			endpointsPoller := statsdami.NewPoller(*endpointsInterval, func() {
+				statsdami.PollEndpoints(statsdclient, amiClient, time.Second*30)
+			})
+			sweeper := statsdami.NewPoller(config.sweeperInterval(), func() {
+				statsdami.SweepCalls(statsdclient, amiClient, time.Second*30)
+			})
+
 			amiClient.Run()
 			logging.Info.Println("Connection lost")
+			setLinkUp(false)
 			amiClient.StopKeepAlive()
+			endpointsPoller.Stop()
+			sweeper.Stop()
+			systemPoller.Stop()
+			statsdami.SystemDisconnected(statsdclient)
 		}
 		// reconnect after 100ms
 		<-time.After(time.Millisecond * 100)
@@ -145,6 +256,85 @@ func main() {
 	logging.Info.Println("stopped")
 }
 
+// newStatsdClient create the statsd client from its connection info, a NoopClient if info can not be parsed
+func newStatsdClient(info string) (*statsd.Statsd, error) {
+	regexStatsd, _ := regexp.Compile("^(.+?)(:(.*?))?(/(.*?))?$")
+
+	var statsdclient *statsd.Statsd
+	if regexStatsd.MatchString(info) {
+		matches := regexStatsd.FindAllStringSubmatch(info, -1)
+		statsdHost := matches[0][1] + ":" + matches[0][3]
+		statsdPrefix := matches[0][5]
+
+		client := statsd.Statsd(statsd.NewStatsdClient(statsdHost, statsdPrefix))
+		statsdclient = &client
+	} else {
+		logging.Error.Println("statsd disabled")
+		client := statsd.Statsd(statsd.NoopClient{})
+		statsdclient = &client
+	}
+
+	if err := (*statsdclient).CreateSocket(); err != nil {
+		return nil, err
+	}
+	return statsdclient, nil
+}
+
+// registerHandlers register the handlers turning AMI events into metrics
+func registerHandlers(amiClient *ami.Client, statsdclient *statsd.Statsd) {
+	amiClient.RegisterHandler("Newchannel", statsdami.NewHandler(statsdclient, statsdami.EventNewChannelHandler))
+	amiClient.RegisterHandler("Newstate", statsdami.NewHandler(statsdclient, statsdami.EventNewStateHandler))
+	amiClient.RegisterHandler("NewAccountCode", statsdami.NewHandler(statsdclient, statsdami.EventNewAccountCodeHandler))
+	amiClient.RegisterHandler("SoftHangupRequest", statsdami.NewHandler(statsdclient, statsdami.EventSoftHangupHandler))
+	amiClient.RegisterHandler("Hangup", statsdami.NewHandler(statsdclient, statsdami.EventHangupHandler))
+	amiClient.RegisterHandler("Hold", statsdami.NewHandler(statsdclient, statsdami.EventHoldHandler))
+	amiClient.RegisterHandler("MusicOnHoldStart", statsdami.NewHandler(statsdclient, statsdami.EventHoldHandler))
+	amiClient.RegisterHandler("Unhold", statsdami.NewHandler(statsdclient, statsdami.EventUnholdHandler))
+	amiClient.RegisterHandler("MusicOnHoldStop", statsdami.NewHandler(statsdclient, statsdami.EventUnholdHandler))
+	amiClient.RegisterHandler("RTCPSent", statsdami.NewHandler(statsdclient, statsdami.EventRTCPHandler))
+	amiClient.RegisterHandler("RTCPReceived", statsdami.NewHandler(statsdclient, statsdami.EventRTCPHandler))
+	amiClient.RegisterHandler("BridgeEnter", statsdami.NewHandler(statsdclient, statsdami.EventBridgeEnterHandler))
+	amiClient.RegisterHandler("BridgeLeave", statsdami.NewHandler(statsdclient, statsdami.EventBridgeLeaveHandler))
+	amiClient.RegisterHandler("BlindTransfer", statsdami.NewHandler(statsdclient, statsdami.EventBlindTransferHandler))
+	amiClient.RegisterHandler("AttendedTransfer", statsdami.NewHandler(statsdclient, statsdami.EventAttendedTransferHandler))
+
+	amiClient.RegisterHandler("QueueCallerJoin", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueCallerJoinHandler))
+	amiClient.RegisterHandler("QueueCallerLeave", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueCallerLeaveHandler))
+	amiClient.RegisterHandler("QueueCallerAbandon", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueCallerAbandonHandler))
+	amiClient.RegisterHandler("AgentCalled", statsdami.NewQueueHandler(statsdclient, statsdami.EventAgentCalledHandler))
+	amiClient.RegisterHandler("AgentConnect", statsdami.NewQueueHandler(statsdclient, statsdami.EventAgentConnectHandler))
+	amiClient.RegisterHandler("AgentComplete", statsdami.NewQueueHandler(statsdclient, statsdami.EventAgentCompleteHandler))
+	amiClient.RegisterHandler("QueueMemberAdded", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueMemberHandler))
+	amiClient.RegisterHandler("QueueMemberPause", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueMemberHandler))
+	amiClient.RegisterHandler("QueueMemberStatus", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueMemberHandler))
+	amiClient.RegisterHandler("QueueMemberRemoved", statsdami.NewQueueHandler(statsdclient, statsdami.EventQueueMemberRemovedHandler))
+
+	amiClient.RegisterHandler("FullyBooted", statsdami.NewSystemHandler(statsdclient, statsdami.EventFullyBootedHandler))
+	amiClient.RegisterHandler("PeerStatus", statsdami.NewEndpointHandler(statsdclient, statsdami.EventPeerStatusHandler))
+	amiClient.RegisterHandler("ContactStatus", statsdami.NewEndpointHandler(statsdclient, statsdami.EventContactStatusHandler))
+	amiClient.RegisterHandler("DeviceStateChange", statsdami.NewEndpointHandler(statsdclient, statsdami.EventDeviceStateChangeHandler))
+}
+
+func setLinkUp(up bool) {
+	linkMutex.Lock()
+	defer linkMutex.Unlock()
+	if up {
+		linkDownSince = time.Time{}
+	} else {
+		linkDownSince = time.Now()
+	}
+}
+
+// linkDownSource alerting source: seconds since the AMI link is down, 0 if up
+func linkDownSource(window time.Duration, params map[string]string) map[string]float64 {
+	linkMutex.RLock()
+	defer linkMutex.RUnlock()
+	if linkDownSince.IsZero() {
+		return map[string]float64{"ami": 0}
+	}
+	return map[string]float64{"ami": time.Since(linkDownSince).Seconds()}
+}
+
 func shouldStop() bool {
 	stopMutex.Lock()
 	defer stopMutex.Unlock()
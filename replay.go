@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+	"github.com/pgoergler/go-asterisk-statsd/statsd-ami"
+
+	"github.com/quipo/statsd"
+)
+
+// replay feed recorded AMI sessions to the handlers
+//
+//	go-asterisk-statsd replay [-config=file] [-speed=1] [-statsd=host:port/prefix] session...
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	statsdInfo := flags.String("statsd", "", "statsd connection info. format: host:port/prefix, print the metrics on stdout if empty")
+	configFile := flags.String("config", "", "json configuration file")
+	speed := flags.Float64("speed", 1, "replay speed, 1 for real speed, 0 for as fast as possible")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		logging.Error.Println("no session file to replay")
+		os.Exit(1)
+	}
+
+	// metrics are printed on stdout
+	logging.Init(logging.Debug, os.Stderr)
+	logging.Init(logging.Info, os.Stderr)
+	logging.Init(logging.Warning, os.Stderr)
+	logging.Init(logging.Error, os.Stderr)
+
+	config, err := loadConfig(*configFile)
+	if err == nil {
+		err = applyConfig(config)
+	}
+	if err != nil {
+		logging.Error.Println("could not load configuration <"+(*configFile)+">:", err)
+		os.Exit(1)
+	}
+
+	var statsdclient *statsd.Statsd
+	if *statsdInfo == "" {
+		client := statsd.Statsd(statsdami.NewPrintClient(os.Stdout, ""))
+		statsdclient = &client
+	} else if statsdclient, err = newStatsdClient(*statsdInfo); err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+
+	// no connection: actions (fraud hangups, polls) are not available
+	amiClient := ami.New("", "", "", ami.OnPanic(statsdami.NewPanicCounter(statsdclient)))
+	if err := config.applyFraudConfig(nil, nil); err != nil {
+		logging.Error.Println("could not create fraud detector:", err)
+		os.Exit(1)
+	}
+	registerHandlers(amiClient, statsdclient)
+
+	for _, name := range flags.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			logging.Error.Println("could not open session <"+name+">:", err)
+			os.Exit(1)
+		}
+		err = amiClient.Replay(file, *speed)
+		file.Close()
+		if err != nil {
+			logging.Error.Println("could not replay session <"+name+">:", err)
+			os.Exit(1)
+		}
+	}
+
+	statsdami.PublishKPIs(statsdclient)
+	logging.Info.Println("Pending calls:", statsdami.GetPendingCallsCount())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+	"github.com/pgoergler/go-asterisk-statsd/simulator"
+)
+
+// simulate act as an AMI server generating call flows and print the expected metrics
+//
+//	go-asterisk-statsd simulate [-listen=127.0.0.1:5038] [-rate=10] [-concurrency=100] [-calls=0] ...
+func simulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:5038", "address the fake AMI listens on")
+	username := flags.String("username", "admin", "AMI user name")
+	secret := flags.String("secret", "admin", "AMI secret")
+	rate := flags.Float64("rate", 10, "new calls per second")
+	concurrency := flags.Int("concurrency", 100, "maximum calls in progress")
+	calls := flags.Int("calls", 0, "number of calls to generate, 0 until interrupted")
+	talkTime := flags.Duration("talk-time", 10*time.Second, "average duration of answered calls")
+	ringTime := flags.Duration("ring-time", 5*time.Second, "average ringing duration of unanswered calls")
+	mix := flags.String("mix", "", "weights of the call flows, for example answered=60,busy=10,noanswer=15,failed=5,transfer=5,local=5")
+	wait := flags.Duration("wait", time.Minute, "how long to wait for the daemon to connect")
+	flags.Parse(args)
+
+	weights, err := parseMix(*mix)
+	if err != nil {
+		logging.Error.Println("could not parse mix <"+(*mix)+">:", err)
+		os.Exit(1)
+	}
+
+	server, err := amitest.Listen(*listen, *username, *secret)
+	if err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+	defer server.Close()
+
+	sim := simulator.New(server, simulator.Options{
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Calls:       *calls,
+		TalkTime:    *talkTime,
+		RingTime:    *ringTime,
+		Mix:         weights,
+	})
+
+	logging.Info.Println("waiting for the daemon on", server.Addr())
+	if err := server.WaitConnections(1, *wait); err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
+	go func() {
+		for sig := range sigChan {
+			if sig == syscall.SIGUSR1 {
+				sim.Expected().Print(os.Stdout)
+				continue
+			}
+			logging.Info.Println("stopping, hanging up the calls in progress")
+			sim.Stop()
+		}
+	}()
+
+	logging.Info.Println("daemon connected, simulating")
+	sim.Run()
+	sim.Expected().Print(os.Stdout)
+}
+
+// parseMix parse flow=weight pairs separated by commas
+func parseMix(mix string) (map[string]int, error) {
+	weights := make(map[string]int)
+	if mix == "" {
+		return weights, nil
+	}
+	for _, pair := range strings.Split(mix, ",") {
+		values := strings.SplitN(pair, "=", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("%q is not flow=weight", pair)
+		}
+		flow := strings.TrimSpace(values[0])
+		if _, found := simulator.DefaultMix[flow]; !found {
+			return nil, fmt.Errorf("unknown flow %q", flow)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(values[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", values[1])
+		}
+		weights[flow] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,253 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+)
+
+// channel a simulated Asterisk channel
+type channel struct {
+	name      string
+	uniqueID  string
+	linkedID  string
+	callerID  string
+	exten     string
+	context   string
+	answered  bool
+	hungUp    bool
+	bridgeIDs []string
+}
+
+// run play a flow
+func (s *Simulator) run(flow string) {
+	s.mutex.Lock()
+	s.expected.Flows[flow]++
+	s.mutex.Unlock()
+
+	switch flow {
+	case FlowAnswered:
+		caller := s.inbound()
+		s.state(caller, "Ring")
+		s.wait(s.jitter(s.options.RingTime) / 4)
+		s.answer(caller)
+		s.wait(s.jitter(s.options.TalkTime))
+		s.hangup(caller, "16", "Normal Clearing")
+	case FlowBusy:
+		caller := s.inbound()
+		s.state(caller, "Busy")
+		s.hangup(caller, "17", "User busy")
+	case FlowNoAnswer:
+		caller := s.inbound()
+		s.state(caller, "Ringing")
+		s.wait(s.jitter(s.options.RingTime))
+		s.hangup(caller, "19", "No user responding")
+	case FlowFailed:
+		caller := s.inbound()
+		s.hangup(caller, "34", "Circuit/channel congestion")
+	case FlowTransfer:
+		s.transfer()
+	case FlowLocal:
+		s.local()
+	}
+}
+
+// transfer an inbound call answered by an agent blind transferred to another one
+func (s *Simulator) transfer() {
+	caller := s.inbound()
+	s.state(caller, "Ring")
+	agent := s.newChannel("PJSIP/100", "100", "s", "from-internal", caller)
+	s.answer(agent)
+	s.answer(caller)
+	first := s.nextID()
+	s.bridgeEnter(caller, first)
+	s.bridgeEnter(agent, first)
+	s.wait(s.jitter(s.options.TalkTime) / 2)
+
+	s.push(caller, amitest.Frame{
+		"Event":              "BlindTransfer",
+		"Result":             "Success",
+		"TransfererChannel":  agent.name,
+		"TransfererUniqueid": agent.uniqueID,
+		"TransfereeChannel":  caller.name,
+		"TransfereeUniqueid": caller.uniqueID,
+		"Extension":          "200",
+		"Context":            "from-internal",
+	})
+	s.mutex.Lock()
+	s.expected.Transfers++
+	s.mutex.Unlock()
+	s.hangup(agent, "16", "Normal Clearing")
+
+	target := s.newChannel("PJSIP/200", "200", "s", "from-internal", caller)
+	s.state(target, "Ringing")
+	s.wait(s.jitter(s.options.RingTime) / 4)
+	s.answer(target)
+	second := s.nextID()
+	s.bridgeEnter(caller, second)
+	s.bridgeEnter(target, second)
+	s.wait(s.jitter(s.options.TalkTime) / 2)
+	s.hangup(target, "16", "Normal Clearing")
+	s.hangup(caller, "16", "Normal Clearing")
+}
+
+// local an internal call through a Local channel pair
+func (s *Simulator) local() {
+	id := s.nextID()
+	name := fmt.Sprintf("Local/100@from-internal-%08x", id)
+	first := s.newChannel(name+";1", "200", "100", "from-internal", nil)
+	second := s.newChannel(name+";2", "200", "100", "from-internal", first)
+	s.answer(second)
+	s.answer(first)
+	s.wait(s.jitter(s.options.TalkTime))
+	s.hangup(first, "16", "Normal Clearing")
+	s.hangup(second, "16", "Normal Clearing")
+}
+
+// inbound create a channel from the provider trunk
+func (s *Simulator) inbound() *channel {
+	s.mutex.Lock()
+	callerID := fmt.Sprintf("06%08d", s.random.Intn(100000000))
+	s.mutex.Unlock()
+	return s.newChannel("SIP/provider", callerID, "100", "from-trunk", nil)
+}
+
+func (s *Simulator) nextID() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sequence++
+	return s.sequence
+}
+
+// newChannel create a channel and push its Newchannel, prefix gets a unique suffix unless it is a Local channel
+//
+// linked is the channel which originated this one, nil for a new call
+func (s *Simulator) newChannel(prefix string, callerID string, exten string, context string, linked *channel) *channel {
+	id := s.nextID()
+	name := prefix
+	if !strings.HasPrefix(prefix, "Local/") {
+		name = fmt.Sprintf("%s-%08x", prefix, id)
+	}
+	c := &channel{
+		name:     name,
+		uniqueID: fmt.Sprintf("%d.%d", time.Now().Unix(), id),
+		callerID: callerID,
+		exten:    exten,
+		context:  context,
+	}
+	c.linkedID = c.uniqueID
+	if linked != nil {
+		c.linkedID = linked.linkedID
+	}
+
+	s.mutex.Lock()
+	s.expected.Calls++
+	s.expected.Concurrent++
+	if s.expected.Concurrent > s.expected.MaxConcurrent {
+		s.expected.MaxConcurrent = s.expected.Concurrent
+	}
+	s.channels[c.name] = true
+	s.mutex.Unlock()
+
+	s.push(c, amitest.Frame{
+		"Event":            "Newchannel",
+		"ChannelState":     "0",
+		"ChannelStateDesc": "Down",
+	})
+	return c
+}
+
+func (s *Simulator) state(c *channel, state string) {
+	s.push(c, amitest.Frame{"Event": "Newstate", "ChannelStateDesc": state})
+}
+
+func (s *Simulator) answer(c *channel) {
+	c.answered = true
+	s.state(c, "Up")
+	if s.options.Sync != nil {
+		s.options.Sync()
+	}
+}
+
+func (s *Simulator) bridgeEnter(c *channel, id int64) {
+	bridgeID := fmt.Sprintf("%08x-0000-0000-0000-%012x", id, id)
+	c.bridgeIDs = append(c.bridgeIDs, bridgeID)
+	s.push(c, amitest.Frame{"Event": "BridgeEnter", "BridgeUniqueid": bridgeID, "BridgeType": "basic"})
+}
+
+// hangup leave the bridges and hang the channel up
+func (s *Simulator) hangup(c *channel, cause string, causeTxt string) {
+	if c.hungUp {
+		return
+	}
+	c.hungUp = true
+
+	for _, bridgeID := range c.bridgeIDs {
+		s.push(c, amitest.Frame{"Event": "BridgeLeave", "BridgeUniqueid": bridgeID, "BridgeType": "basic"})
+	}
+	if c.answered {
+		s.push(c, amitest.Frame{"Event": "SoftHangupRequest", "Cause": cause})
+	}
+	s.push(c, amitest.Frame{"Event": "Hangup", "Cause": cause, "Cause-txt": causeTxt})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expected.Bridged += int64(len(c.bridgeIDs))
+	s.expected.Concurrent--
+	s.expected.Dispositions[disposition(c, cause)]++
+	delete(s.channels, c.name)
+}
+
+// disposition return the disposition the daemon gives to a channel
+func disposition(c *channel, cause string) string {
+	switch cause {
+	case "16":
+		if c.answered {
+			return "ANSWERED"
+		}
+		return "NOANSWER"
+	case "17":
+		return "BUSY"
+	case "19":
+		return "NOANSWER"
+	}
+	return "FAILED"
+}
+
+// push send an event of a channel
+func (s *Simulator) push(c *channel, event amitest.Frame) {
+	event["Privilege"] = "call,all"
+	event["Channel"] = c.name
+	event["Uniqueid"] = c.uniqueID
+	event["Linkedid"] = c.linkedID
+	event["CallerIDNum"] = c.callerID
+	event["Exten"] = c.exten
+	event["Context"] = c.context
+	if err := s.server.Push(event); err != nil {
+		s.mutex.Lock()
+		s.expected.Lost++
+		s.mutex.Unlock()
+	}
+}
+
+// wait d, or less if the simulation is stopped
+func (s *Simulator) wait(d time.Duration) {
+	select {
+	case <-s.stop:
+		// still long enough for the daemon to see the answered calls as answered
+		time.Sleep(minTalkTime / 2)
+	case <-time.After(d):
+	}
+}
+
+// jitter return a random duration between d/2 and 3d/2
+func (s *Simulator) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return d/2 + time.Duration(s.random.Int63n(int64(d)))
+}
@@ -0,0 +1,300 @@
+// Package simulator generates synthetic AMI call flows to load test the daemon
+// and compare the metrics it emits with the expected ones
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+)
+
+// Call flows
+const (
+	FlowAnswered = "answered"
+	FlowBusy     = "busy"
+	FlowNoAnswer = "noanswer"
+	FlowFailed   = "failed"
+	FlowTransfer = "transfer"
+	FlowLocal    = "local"
+)
+
+// DefaultMix weights of the flows when Options.Mix is empty
+var DefaultMix = map[string]int{
+	FlowAnswered: 60,
+	FlowBusy:     10,
+	FlowNoAnswer: 15,
+	FlowFailed:   5,
+	FlowTransfer: 5,
+	FlowLocal:    5,
+}
+
+// minTalkTime answered calls shorter than 1ms are not answered for the daemon
+const minTalkTime = 10 * time.Millisecond
+
+// Options of a simulation
+type Options struct {
+	// Rate new flows per second
+	Rate float64
+	// Concurrency maximum flows in progress, new flows are delayed when reached
+	Concurrency int
+	// Calls number of flows to generate, 0 until stopped
+	Calls int
+	// TalkTime average duration of answered calls, at least 10ms
+	TalkTime time.Duration
+	// RingTime average ringing duration of unanswered calls
+	RingTime time.Duration
+	// Mix weights of the flows, DefaultMix if empty or all zero
+	Mix map[string]int
+	// Sync called once a channel is answered, before its talk time: waiting there until the daemon
+	// handled the pushed events makes the talk time it measures at least the simulated one
+	Sync func()
+}
+
+// Expected ground truth of the metrics the daemon should emit with trunk=All
+type Expected struct {
+	// Flows started per flow
+	Flows map[string]int64
+	// Calls channels created: calls
+	Calls int64
+	// Dispositions channels hung up per disposition: total_duration count
+	Dispositions map[string]int64
+	// Transfers successful blind transfers: transfers type=blind result=Success
+	Transfers int64
+	// Bridged channels leaving a bridge: bridged_duration count
+	Bridged int64
+	// Concurrent channels in progress: concurrent
+	Concurrent int64
+	// MaxConcurrent highest Concurrent
+	MaxConcurrent int64
+	// Lost events which could not be sent, the daemon was not connected
+	Lost int64
+	// Elapsed duration of the simulation
+	Elapsed time.Duration
+}
+
+// Simulator push call flows to the connections of an amitest.Server
+type Simulator struct {
+	server  *amitest.Server
+	options Options
+	flows   []string
+	weights []int
+	total   int
+
+	mutex    *sync.Mutex
+	random   *rand.Rand
+	sequence int64
+	expected Expected
+	channels map[string]bool
+	started  time.Time
+	stop     chan struct{}
+}
+
+// New create a Simulator pushing to server and answer the actions polled by the daemon
+func New(server *amitest.Server, options Options) *Simulator {
+	if options.Rate <= 0 {
+		options.Rate = 1
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if options.TalkTime < minTalkTime {
+		options.TalkTime = minTalkTime
+	}
+	mix := DefaultMix
+	for _, weight := range options.Mix {
+		if weight > 0 {
+			mix = options.Mix
+			break
+		}
+	}
+
+	s := &Simulator{
+		server:   server,
+		options:  options,
+		mutex:    new(sync.Mutex),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:     make(chan struct{}),
+		channels: make(map[string]bool),
+		expected: Expected{
+			Flows:        make(map[string]int64),
+			Dispositions: make(map[string]int64),
+		},
+	}
+	for flow, weight := range mix {
+		if weight > 0 {
+			s.flows = append(s.flows, flow)
+		}
+	}
+	sort.Strings(s.flows)
+	for _, flow := range s.flows {
+		s.weights = append(s.weights, mix[flow])
+		s.total += mix[flow]
+	}
+
+	s.handleActions()
+	return s
+}
+
+// Stop interrupt the simulation, flows in progress are hung up
+func (s *Simulator) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+// Run generate the flows until Options.Calls flows are finished or Stop is called
+func (s *Simulator) Run() {
+	s.mutex.Lock()
+	s.started = time.Now()
+	s.mutex.Unlock()
+
+	slots := make(chan struct{}, s.options.Concurrency)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.options.Rate))
+	defer ticker.Stop()
+
+	group := new(sync.WaitGroup)
+	for n := 0; s.options.Calls == 0 || n < s.options.Calls; n++ {
+		select {
+		case <-s.stop:
+			group.Wait()
+			return
+		case <-ticker.C:
+		}
+
+		select {
+		case <-s.stop:
+			group.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+
+		flow := s.pick()
+		group.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				group.Done()
+			}()
+			s.run(flow)
+		}()
+	}
+	group.Wait()
+}
+
+// Expected return the metrics the daemon should have emitted so far
+func (s *Simulator) Expected() Expected {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expected := s.expected
+	expected.Flows = make(map[string]int64)
+	for k, v := range s.expected.Flows {
+		expected.Flows[k] = v
+	}
+	expected.Dispositions = make(map[string]int64)
+	for k, v := range s.expected.Dispositions {
+		expected.Dispositions[k] = v
+	}
+	if !s.started.IsZero() {
+		expected.Elapsed = time.Since(s.started)
+	}
+	return expected
+}
+
+// Print write the expected metrics
+func (e Expected) Print(w io.Writer) {
+	var flows int64
+	for _, count := range e.Flows {
+		flows += count
+	}
+	rate := 0.0
+	if e.Elapsed > 0 {
+		rate = float64(flows) / e.Elapsed.Seconds()
+	}
+
+	fmt.Fprintf(w, "flows: %d in %s (%.2f/s)\n", flows, e.Elapsed, rate)
+	for _, flow := range sortedKeys(e.Flows) {
+		fmt.Fprintf(w, "  %-10s %d\n", flow, e.Flows[flow])
+	}
+	fmt.Fprintln(w, "expected metrics (trunk=All):")
+	fmt.Fprintf(w, "  %-50s %d\n", "calls", e.Calls)
+	for _, disposition := range sortedKeys(e.Dispositions) {
+		fmt.Fprintf(w, "  %-50s %d\n", "total_duration count disposition="+disposition, e.Dispositions[disposition])
+	}
+	fmt.Fprintf(w, "  %-50s %d\n", "transfers type=blind result=Success", e.Transfers)
+	fmt.Fprintf(w, "  %-50s %d\n", "bridged_duration count", e.Bridged)
+	fmt.Fprintf(w, "  %-50s %d\n", "concurrent", e.Concurrent)
+	fmt.Fprintf(w, "  %-50s %d\n", "concurrent maximum", e.MaxConcurrent)
+	if e.Lost > 0 {
+		fmt.Fprintf(w, "%d events lost, the daemon was not connected: the expected metrics are not reliable\n", e.Lost)
+	}
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pick a flow according to the mix weights
+func (s *Simulator) pick() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := s.random.Intn(s.total)
+	for i, weight := range s.weights {
+		if n < weight {
+			return s.flows[i]
+		}
+		n -= weight
+	}
+	return s.flows[len(s.flows)-1]
+}
+
+// handleActions answer the actions sent by the daemon at connection and by its pollers
+func (s *Simulator) handleActions() {
+	startedAt := time.Now()
+	s.server.HandleAction("CoreSettings", func(action amitest.Frame) []amitest.Frame {
+		return []amitest.Frame{{"Response": "Success", "AsteriskVersion": "13.17.0", "CoreMaxCalls": "0"}}
+	})
+	s.server.HandleAction("CoreStatus", func(action amitest.Frame) []amitest.Frame {
+		return []amitest.Frame{{
+			"Response":         "Success",
+			"CoreStartupDate":  startedAt.Format("2006-01-02"),
+			"CoreStartupTime":  startedAt.Format("15:04:05"),
+			"CoreReloadDate":   startedAt.Format("2006-01-02"),
+			"CoreReloadTime":   startedAt.Format("15:04:05"),
+			"CoreCurrentCalls": fmt.Sprint(s.Expected().Concurrent),
+		}}
+	})
+	s.server.HandleAction("QueueStatus", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("QueueStatusComplete")
+	})
+	s.server.HandleAction("PJSIPShowEndpoints", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("EndpointListComplete")
+	})
+	s.server.HandleAction("SIPpeers", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("PeerlistComplete")
+	})
+	s.server.HandleAction("Status", func(action amitest.Frame) []amitest.Frame {
+		name := action.Get("Channel")
+		s.mutex.Lock()
+		active := s.channels[name]
+		s.mutex.Unlock()
+		if !active {
+			return []amitest.Frame{amitest.Error("No such channel")}
+		}
+		return amitest.EventList("StatusComplete", amitest.Frame{"Event": "Status", "Channel": name})
+	})
+}
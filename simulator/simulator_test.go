@@ -0,0 +1,86 @@
+package simulator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/pgoergler/go-asterisk-statsd/statsd-ami"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatorMatchesDaemonMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var output bytes.Buffer
+	client := statsd.Statsd(statsdami.NewPrintClient(&output, ""))
+	// channels of a call handled concurrently: the tracker must not depend on the events order between channels
+	amiClient := ami.New(server.Addr(), "admin", "secret", ami.DispatchWorkers(8), ami.ShardBy(ami.UniqueIDShardKey), ami.StrictHandlers)
+	amiClient.RegisterHandler("Newchannel", statsdami.NewHandler(&client, statsdami.EventNewChannelHandler))
+	amiClient.RegisterHandler("Newstate", statsdami.NewHandler(&client, statsdami.EventNewStateHandler))
+	amiClient.RegisterHandler("SoftHangupRequest", statsdami.NewHandler(&client, statsdami.EventSoftHangupHandler))
+	amiClient.RegisterHandler("Hangup", statsdami.NewHandler(&client, statsdami.EventHangupHandler))
+	amiClient.RegisterHandler("BridgeEnter", statsdami.NewHandler(&client, statsdami.EventBridgeEnterHandler))
+	amiClient.RegisterHandler("BridgeLeave", statsdami.NewHandler(&client, statsdami.EventBridgeLeaveHandler))
+	amiClient.RegisterHandler("BlindTransfer", statsdami.NewHandler(&client, statsdami.EventBlindTransferHandler))
+	if err := amiClient.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go amiClient.Run()
+	defer amiClient.Close()
+
+	// handled wait until the pushed events are handled: events are read in order,
+	// once the ping is answered they have all been queued
+	handled := func() {
+		_, err := amiClient.Ping()
+		assert.Nil(err)
+		amiClient.Flush()
+	}
+
+	// the daemon times the calls when it handles their events: sync on each answer
+	// for the queued events not to shorten the talk times it measures
+	sim := New(server, Options{
+		Rate:        1000,
+		Concurrency: 20,
+		Calls:       60,
+		TalkTime:    10 * time.Millisecond,
+		RingTime:    10 * time.Millisecond,
+		Sync:        handled,
+	})
+	sim.Run()
+	handled()
+
+	expected := sim.Expected()
+	assert.Equal(int64(0), expected.Lost, "events lost")
+	assert.Equal(int64(0), expected.Concurrent, "calls still in progress")
+
+	// count the trunk=All metrics starting with prefix, containing tag and ending with suffix
+	count := func(prefix string, tag string, suffix string) int64 {
+		var n int64
+		for _, line := range strings.Split(output.String(), "\n") {
+			if strings.HasPrefix(line, prefix) && strings.Contains(line, ",trunk=All") &&
+				strings.Contains(line, tag) && strings.HasSuffix(line, suffix) {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(expected.Calls, count("calls,", "", "|c"), "calls not correctly counted")
+	assert.Equal(expected.Calls, count("concurrent,", "", ":+1|g"), "concurrent not correctly incremented")
+	assert.Equal(expected.Calls, count("concurrent,", "", ":-1|g"), "concurrent not correctly decremented")
+	assert.Equal(expected.Transfers, count("transfers,", "result=Success", ",type=blind:1|c"), "transfers not correctly counted")
+	assert.Equal(expected.Bridged, count("bridged_duration,", "", "|ms"), "bridged durations not correctly emitted")
+	for disposition, n := range expected.Dispositions {
+		assert.Equal(n, count("total_duration,", "disposition="+disposition+",", "|ms"), "disposition "+disposition+" not correctly emitted")
+	}
+}
@@ -0,0 +1,64 @@
+package statsdami
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/alerting"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+)
+
+// AlertSources return the alerting sources computed from the calls, keyed by trunk
+//
+//	asr: percentage of answered calls over the window, params: min_calls (default 1)
+//	calls: number of calls finished over the window
+//	cause: number of calls hung up with params cause over the window
+//	concurrent: number of calls in progress
+func AlertSources() map[string]alerting.Source {
+	return map[string]alerting.Source{
+		"asr":        asrSource,
+		"calls":      callsSource,
+		"cause":      causeSource,
+		"concurrent": concurrentSource,
+	}
+}
+
+func asrSource(window time.Duration, params map[string]string) map[string]float64 {
+	minCalls, err := strconv.ParseInt(params["min_calls"], 10, 64)
+	if err != nil || minCalls < 1 {
+		minCalls = 1
+	}
+
+	values := make(map[string]float64)
+	for trunk, stats := range GetWindowStats(window) {
+		if stats.Calls >= minCalls {
+			values[trunk] = stats.ASR()
+		}
+	}
+	return values
+}
+
+func callsSource(window time.Duration, params map[string]string) map[string]float64 {
+	values := make(map[string]float64)
+	for trunk, stats := range GetWindowStats(window) {
+		values[trunk] = float64(stats.Calls)
+	}
+	return values
+}
+
+func causeSource(window time.Duration, params map[string]string) map[string]float64 {
+	values := make(map[string]float64)
+	for trunk, stats := range GetWindowStats(window) {
+		values[trunk] = float64(stats.Causes[params["cause"]])
+	}
+	return values
+}
+
+func concurrentSource(window time.Duration, params map[string]string) map[string]float64 {
+	values := map[string]float64{"All": 0}
+	forEachCall(func(call *asterisk.Call) {
+		values[call.GetTrunkName()]++
+		values["All"]++
+	})
+	return values
+}
@@ -0,0 +1,201 @@
+package statsdami
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+)
+
+// DefaultOtherValue value replacing the tag values over the limit
+const DefaultOtherValue = "other"
+
+// TagLimit cardinality limit of a tag, over all the metrics
+//
+//	MaxValues: distinct values emitted, the next ones are replaced by Other. Unlimited if 0 without Allow nor Patterns
+//	Allow: values always emitted, not counted in MaxValues
+//	Patterns: regular expressions of the values always emitted, not counted in MaxValues
+//	Other: value replacing the values over the limit, "other" by default
+type TagLimit struct {
+	MaxValues int      `json:"max_values"`
+	Allow     []string `json:"allow"`
+	Patterns  []string `json:"patterns"`
+	Other     string   `json:"other"`
+}
+
+// CardinalityLimiter replace the tag values over their TagLimit
+type CardinalityLimiter struct {
+	tags map[string]*tagLimiter
+}
+
+// tagLimiter the state of a TagLimit
+type tagLimiter struct {
+	maxValues int
+	allow     map[string]bool
+	patterns  []*regexp.Regexp
+	other     string
+
+	mutex   *sync.Mutex
+	values  map[string]bool
+	limited uint64
+}
+
+// DefaultTagLimits limits of the tags whose values are not bounded by the PBX configuration,
+// a tag set in the cardinality configuration overrides its default limit
+var DefaultTagLimits = map[string]TagLimit{
+	"account": {MaxValues: 100, Allow: []string{"-"}},
+}
+
+var limiterMutex = new(sync.RWMutex)
+var limiter, _ = NewCardinalityLimiter(DefaultTagLimits)
+
+// NewCardinalityLimiter create a CardinalityLimiter from the limits per tag
+func NewCardinalityLimiter(limits map[string]TagLimit) (*CardinalityLimiter, error) {
+	l := &CardinalityLimiter{tags: make(map[string]*tagLimiter)}
+	for tag, limit := range limits {
+		t := &tagLimiter{
+			maxValues: limit.MaxValues,
+			allow:     make(map[string]bool),
+			other:     limit.Other,
+			mutex:     new(sync.Mutex),
+			values:    make(map[string]bool),
+		}
+		if t.other == "" {
+			t.other = DefaultOtherValue
+		}
+		// the rollup value and the replacement value are always emitted
+		t.allow[RollupValue] = true
+		t.allow[t.other] = true
+		for _, value := range limit.Allow {
+			t.allow[value] = true
+		}
+		for _, pattern := range limit.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("tag %s: %s", tag, err)
+			}
+			t.patterns = append(t.patterns, re)
+		}
+		if t.maxValues <= 0 && len(limit.Allow) == 0 && len(t.patterns) == 0 {
+			continue
+		}
+		l.tags[tag] = t
+	}
+	return l, nil
+}
+
+// SetCardinalityLimiter set the limiter applied to every metric, nil to emit the tag values as they are,
+// DefaultTagLimits are applied until it is set
+func SetCardinalityLimiter(l *CardinalityLimiter) {
+	limiterMutex.Lock()
+	defer limiterMutex.Unlock()
+	limiter = l
+}
+
+func getCardinalityLimiter() *CardinalityLimiter {
+	limiterMutex.RLock()
+	defer limiterMutex.RUnlock()
+	return limiter
+}
+
+// limit return the tags with the values over their limit replaced, tags is not modified
+func (l *CardinalityLimiter) limit(tags map[string]string) map[string]string {
+	if l == nil {
+		return tags
+	}
+	var limited map[string]string
+	for tag, value := range tags {
+		t, found := l.tags[tag]
+		if !found {
+			continue
+		}
+		if replaced := t.limit(tag, value); replaced != value {
+			if limited == nil {
+				limited = copyTags(tags)
+			}
+			limited[tag] = replaced
+		}
+	}
+	if limited == nil {
+		return tags
+	}
+	return limited
+}
+
+// limit return value, or the other value if value is over the limit
+func (t *tagLimiter) limit(tag string, value string) string {
+	if t.allow[value] {
+		return value
+	}
+	for _, re := range t.patterns {
+		if re.MatchString(value) {
+			return value
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.values[value] {
+		return value
+	}
+	if len(t.values) < t.maxValues {
+		t.values[value] = true
+		return value
+	}
+	if t.limited == 0 {
+		logging.Warning.Println("tag", tag, "reached its limit of", t.maxValues, "values, new values are replaced by", t.other)
+	}
+	t.limited++
+	return t.other
+}
+
+// CardinalityStats distinct values emitted and values replaced of a limited tag
+type CardinalityStats struct {
+	Values  int
+	Limited uint64
+}
+
+// GetCardinalityStats return the stats of the limited tags
+func GetCardinalityStats() map[string]CardinalityStats {
+	stats := make(map[string]CardinalityStats)
+	l := getCardinalityLimiter()
+	if l == nil {
+		return stats
+	}
+	for tag, t := range l.tags {
+		t.mutex.Lock()
+		stats[tag] = CardinalityStats{Values: len(t.values), Limited: t.limited}
+		t.mutex.Unlock()
+	}
+	return stats
+}
+
+// DumpCardinality dump the values emitted per limited tag
+func DumpCardinality(logger *log.Logger) {
+	l := getCardinalityLimiter()
+	if l == nil {
+		return
+	}
+	for tag, t := range l.tags {
+		t.mutex.Lock()
+		values := make([]string, 0, len(t.values))
+		for value := range t.values {
+			values = append(values, value)
+		}
+		limited := t.limited
+		t.mutex.Unlock()
+		sort.Strings(values)
+		logger.Printf("%s => %d values, %d limited: %v\n", tag, len(values), limited, values)
+	}
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
+}
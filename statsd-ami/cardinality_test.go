@@ -0,0 +1,75 @@
+package statsdami
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter, err := NewCardinalityLimiter(map[string]TagLimit{
+		"trunk":     {MaxValues: 2, Patterns: []string{"^provider-"}},
+		"cause_txt": {Allow: []string{"Normal Clearing"}, Other: "-"},
+		"direction": {},
+	})
+	assert.Nil(err)
+	defer SetCardinalityLimiter(getCardinalityLimiter())
+	SetCardinalityLimiter(limiter)
+	assert.Nil(SetRollups(nil))
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	for _, trunk := range []string{"provider-a", "gw1", "gw2", "gw3", "provider-b", "gw1", "All"} {
+		NewMeasure(&client, "calls", map[string]string{"trunk": trunk, "direction": "inbound"}).IncrementCounter()
+	}
+	NewMeasure(&client, "hangups", map[string]string{"cause_txt": "Normal Clearing"}).IncrementCounter()
+	NewMeasure(&client, "hangups", map[string]string{"cause_txt": "User busy"}).IncrementCounter()
+
+	assert.Equal([]string{
+		"calls,direction=inbound,trunk=provider-a:1|c",
+		"calls,direction=inbound,trunk=gw1:1|c",
+		"calls,direction=inbound,trunk=gw2:1|c",
+		"calls,direction=inbound,trunk=other:1|c",
+		"calls,direction=inbound,trunk=provider-b:1|c",
+		"calls,direction=inbound,trunk=gw1:1|c",
+		"calls,direction=inbound,trunk=All:1|c",
+		"hangups,cause_txt=Normal Clearing:1|c",
+		"hangups,cause_txt=-:1|c",
+	}, strings.Split(strings.TrimSpace(output.String()), "\n"), "tag values not limited")
+
+	assert.Equal(map[string]CardinalityStats{
+		"trunk":     {Values: 2, Limited: 1},
+		"cause_txt": {Values: 0, Limited: 1},
+	}, GetCardinalityStats(), "limited values not counted")
+
+	_, err = NewCardinalityLimiter(map[string]TagLimit{"trunk": {Patterns: []string{"("}}})
+	assert.NotNil(err, "invalid pattern should fail")
+}
+
+func TestDefaultAccountLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter, err := NewCardinalityLimiter(DefaultTagLimits)
+	assert.Nil(err)
+	defer SetCardinalityLimiter(getCardinalityLimiter())
+	SetCardinalityLimiter(limiter)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	for i := 0; i <= DefaultTagLimits["account"].MaxValues; i++ {
+		NewMeasure(&client, "fraud_suspect", nil).Tag("account", strconv.Itoa(i)).IncrementCounter()
+	}
+	NewMeasure(&client, "fraud_suspect", nil).Tag("account", "-").IncrementCounter()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Equal("fraud_suspect,account=99:1|c", lines[99], "account codes under the limit not emitted")
+	assert.Equal("fraud_suspect,account=other:1|c", lines[100], "account codes over the limit not replaced")
+	assert.Equal("fraud_suspect,account=-:1|c", lines[101], "calls without account code should always be emitted")
+}
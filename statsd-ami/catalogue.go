@@ -0,0 +1,152 @@
+package statsdami
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MetricConfig shape of a metric, zero values keep the default
+//
+//	Enabled: false to stop emitting the metric
+//	Name: name of the metric, {tag} is replaced by the mapped value of tag and {name} by the default name
+//	Tags: tags carried by the metric, all of them if empty
+//	Mappings: per tag, mapped values by value, "*" maps the values not listed
+type MetricConfig struct {
+	Enabled  *bool                        `json:"enabled"`
+	Name     string                       `json:"name"`
+	Tags     []string                     `json:"tags"`
+	Mappings map[string]map[string]string `json:"mappings"`
+}
+
+// MetricCatalogue shapes the metrics by default name, the "*" config applies to every metric:
+// a metric config overrides its enabled, name and tags, and its mappings are added to the "*" ones
+type MetricCatalogue struct {
+	configs map[string]MetricConfig
+
+	mutex    *sync.Mutex
+	resolved map[string]*metricShape
+}
+
+// metricShape a MetricConfig merged with the "*" config
+type metricShape struct {
+	enabled  bool
+	name     string
+	tags     map[string]bool
+	mappings map[string]map[string]string
+}
+
+var templateTag = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+var catalogueMutex = new(sync.RWMutex)
+var catalogue *MetricCatalogue
+
+// NewMetricCatalogue create a MetricCatalogue, the names must be valid templates
+func NewMetricCatalogue(configs map[string]MetricConfig) (*MetricCatalogue, error) {
+	for metric, config := range configs {
+		if strings.ContainsAny(templateTag.ReplaceAllString(config.Name, ""), "{}") {
+			return nil, fmt.Errorf("metric %s: invalid name template %q", metric, config.Name)
+		}
+	}
+	return &MetricCatalogue{
+		configs:  configs,
+		mutex:    new(sync.Mutex),
+		resolved: make(map[string]*metricShape),
+	}, nil
+}
+
+// SetMetricCatalogue set the catalogue shaping every metric, nil to emit the default metrics
+func SetMetricCatalogue(c *MetricCatalogue) {
+	catalogueMutex.Lock()
+	defer catalogueMutex.Unlock()
+	catalogue = c
+}
+
+func getMetricCatalogue() *MetricCatalogue {
+	catalogueMutex.RLock()
+	defer catalogueMutex.RUnlock()
+	return catalogue
+}
+
+// shape return the shape of a metric, nil for the default one
+func (c *MetricCatalogue) shape(name string) *metricShape {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if shape, found := c.resolved[name]; found {
+		return shape
+	}
+
+	shape := &metricShape{enabled: true, name: name, mappings: make(map[string]map[string]string)}
+	for _, key := range []string{"*", name} {
+		config, found := c.configs[key]
+		if !found {
+			continue
+		}
+		if config.Enabled != nil {
+			shape.enabled = *config.Enabled
+		}
+		if config.Name != "" {
+			shape.name = config.Name
+		}
+		if len(config.Tags) > 0 {
+			shape.tags = make(map[string]bool)
+			for _, tag := range config.Tags {
+				shape.tags[tag] = true
+			}
+		}
+		for tag, mapping := range config.Mappings {
+			if shape.mappings[tag] == nil {
+				shape.mappings[tag] = make(map[string]string)
+			}
+			for value, mapped := range mapping {
+				shape.mappings[tag][value] = mapped
+			}
+		}
+	}
+	c.resolved[name] = shape
+	return shape
+}
+
+// apply return the name and the tags of a metric once shaped
+func (s *metricShape) apply(name string, tags map[string]string) (string, map[string]string) {
+	mapped := make(map[string]string, len(tags))
+	for tag, value := range tags {
+		mapped[tag] = mapValue(s.mappings[tag], value)
+	}
+
+	name = templateTag.ReplaceAllStringFunc(s.name, func(placeholder string) string {
+		tag := placeholder[1 : len(placeholder)-1]
+		if tag == "name" {
+			return name
+		}
+		return mapped[tag]
+	})
+
+	if s.tags != nil {
+		for tag := range mapped {
+			if !s.tags[tag] {
+				delete(mapped, tag)
+			}
+		}
+	}
+	return name, mapped
+}
+
+// mapValue return the value mapped, the rollup value is never mapped
+func mapValue(mapping map[string]string, value string) string {
+	if value == RollupValue {
+		return value
+	}
+	if mapped, found := mapping[value]; found {
+		return mapped
+	}
+	if mapped, found := mapping["*"]; found {
+		return mapped
+	}
+	return value
+}
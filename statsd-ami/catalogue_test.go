@@ -0,0 +1,48 @@
+package statsdami
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricCatalogue(t *testing.T) {
+	assert := assert.New(t)
+
+	configs := make(map[string]MetricConfig)
+	assert.Nil(json.Unmarshal([]byte(`{
+		"*": {"mappings": {"trunk": {"provider-a": "carrier_a"}}},
+		"calls": {"name": "pbx.{direction}.{name}", "tags": ["trunk"]},
+		"active_duration": {"enabled": false},
+		"total_duration": {"mappings": {"cause": {"16": "normal", "*": "other"}, "trunk": {"provider-b": "carrier_b", "*": "carrier_c"}}}
+	}`), &configs))
+	catalogue, err := NewMetricCatalogue(configs)
+	assert.Nil(err)
+	SetMetricCatalogue(catalogue)
+	defer SetMetricCatalogue(nil)
+	assert.Nil(SetRollups(nil))
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	tags := map[string]string{"trunk": "provider-a", "direction": "inbound"}
+
+	NewMeasure(&client, "calls", tags).IncrementCounter()
+	NewMeasure(&client, "active_duration", tags).Timing(1000)
+	NewMeasure(&client, "total_duration", tags).Tag("cause", "16").Timing(2000)
+	NewMeasure(&client, "total_duration", tags).Tag("cause", "21").Tag("trunk", "provider-b").Timing(3000)
+	NewMeasure(&client, "bridged_duration", tags).Timing(4000)
+	NewMeasure(&client, "total_duration", tags).Tag("cause", "16").Tag("trunk", RollupValue).Timing(5000)
+
+	assert.Equal("pbx.inbound.calls,trunk=carrier_a:1|c\n"+
+		"total_duration,cause=normal,direction=inbound,trunk=carrier_a:2000|ms\n"+
+		"total_duration,cause=other,direction=inbound,trunk=carrier_b:3000|ms\n"+
+		"bridged_duration,direction=inbound,trunk=carrier_a:4000|ms\n"+
+		"total_duration,cause=normal,direction=inbound,trunk=All:5000|ms\n", output.String(), "metrics not shaped")
+
+	_, err = NewMetricCatalogue(map[string]MetricConfig{"calls": {Name: "calls.{direction"}})
+	assert.NotNil(err, "invalid template should fail")
+}
@@ -0,0 +1,261 @@
+package statsdami
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+
+	"github.com/quipo/statsd"
+)
+
+type statsdEndpointHandler func(*statsd.Statsd, *asterisk.Endpoint, *ami.Event, map[string]string)
+
+var endpointsMutex = new(sync.RWMutex)
+var endpoints = make(map[string]*asterisk.Endpoint)
+
+// SIPpeers status: OK (12 ms), LAGGED (2500 ms), UNREACHABLE, UNKNOWN, Unmonitored
+var regexpPeerStatus = regexp.MustCompile("^(OK|LAGGED) \\(([0-9]+) ms\\)$")
+
+// GetEndpointsCount return number of endpoints monitored
+func GetEndpointsCount() int {
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	return len(endpoints)
+}
+
+// DumpEndpoints dump monitored endpoints
+func DumpEndpoints(logger *log.Logger) {
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	logger.Println(len(endpoints), " endpoints")
+	for k, v := range endpoints {
+		logger.Printf("%s => %v\n", k, v)
+	}
+}
+
+// getEndpoint return the endpoint of device, endpointsMutex must be locked
+func getEndpoint(device string, create bool) *asterisk.Endpoint {
+	endpoint, found := endpoints[device]
+	if !found && create {
+		endpoint = asterisk.NewEndpointFromDevice(device)
+		endpoints[device] = endpoint
+	}
+	return endpoint
+}
+
+func endpointTags(endpoint *asterisk.Endpoint) map[string]string {
+	return map[string]string{
+		"trunk":      endpoint.GetTrunkName(),
+		"endpoint":   endpoint.Name,
+		"technology": endpoint.Technology,
+	}
+}
+
+func boolGauge(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// publishEndpoint publish the gauges of an endpoint
+func publishEndpoint(client *statsd.Statsd, endpoint *asterisk.Endpoint, tags map[string]string) {
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "endpoint_registered", tags).Gauge(boolGauge(endpoint.Registered))
+	NewMeasure(client, "endpoint_reachable", tags).Gauge(boolGauge(endpoint.Reachable))
+	if endpoint.RoundTrip >= 0 {
+		NewMeasure(client, "qualify_rtt", tags).Timing(endpoint.RoundTrip)
+	}
+}
+
+// NewEndpointHandler call handler with the Endpoint of the event
+//
+//	handler(*statsd.StatsdClient, *asterisk.Endpoint, *ami.Event, map[string]string)
+//
+// PeerStatus and ContactStatus create the endpoint, DeviceStateChange only updates known endpoints
+func NewEndpointHandler(client *statsd.Statsd, handler statsdEndpointHandler) func(*ami.Event) {
+	return func(message *ami.Event) {
+		get := mapGetter(message.Params)
+
+		device := ""
+		switch message.ID {
+		case "PeerStatus":
+			device = get("Peer", "")
+		case "ContactStatus":
+			if name := get("EndpointName", get("AOR", "")); name != "" {
+				device = "PJSIP/" + name
+			}
+		default:
+			device = get("Device", "")
+		}
+		if device == "" {
+			logging.Error.Println("no endpoint found in", message)
+			return
+		}
+
+		endpointsMutex.Lock()
+		defer endpointsMutex.Unlock()
+		endpoint := getEndpoint(device, message.ID != "DeviceStateChange")
+		if endpoint == nil {
+			return
+		}
+		handler(client, endpoint, message, endpointTags(endpoint))
+	}
+}
+
+// EventPeerStatusHandler handle SIP/IAX2/PJSIP peer registration and qualify changes
+func EventPeerStatusHandler(client *statsd.Statsd,
+	endpoint *asterisk.Endpoint, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	roundTrip, err := strconv.ParseInt(get("Time", ""), 10, 64)
+	if err != nil {
+		roundTrip = -1
+	}
+
+	switch get("PeerStatus", "") {
+	case "Registered":
+		endpoint.Update(true, true, endpoint.RoundTrip)
+	case "Unregistered", "Rejected":
+		endpoint.Update(false, false, -1)
+	case "Reachable", "Lagged":
+		endpoint.Update(endpoint.Registered, true, roundTrip)
+	case "Unreachable":
+		endpoint.Update(endpoint.Registered, false, -1)
+	default:
+		return
+	}
+	publishEndpoint(client, endpoint, tags)
+}
+
+// EventContactStatusHandler handle PJSIP contacts qualify changes
+func EventContactStatusHandler(client *statsd.Statsd,
+	endpoint *asterisk.Endpoint, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	roundTrip, err := strconv.ParseInt(get("RoundtripUsec", ""), 10, 64)
+	if err == nil {
+		roundTrip = roundTrip / 1000
+	} else {
+		roundTrip = -1
+	}
+
+	switch get("ContactStatus", "") {
+	case "Created", "Updated", "NonQualified":
+		endpoint.Update(true, endpoint.Reachable, endpoint.RoundTrip)
+	case "Reachable":
+		endpoint.Update(true, true, roundTrip)
+	case "Unreachable":
+		endpoint.Update(endpoint.Registered, false, -1)
+	case "Removed":
+		endpoint.Update(false, false, -1)
+	default:
+		return
+	}
+	publishEndpoint(client, endpoint, tags)
+}
+
+// EventDeviceStateChangeHandler handle device state changes of known endpoints
+func EventDeviceStateChangeHandler(client *statsd.Statsd,
+	endpoint *asterisk.Endpoint, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	endpoint.DeviceState = get("State", "")
+
+	reachable := endpoint.DeviceState != "UNAVAILABLE" && endpoint.DeviceState != "INVALID"
+	if reachable == endpoint.Reachable {
+		return
+	}
+	endpoint.Update(endpoint.Registered, reachable, endpoint.RoundTrip)
+	publishEndpoint(client, endpoint, tags)
+}
+
+// PollEndpoints list PJSIP endpoints and SIP peers and publish their gauges
+//
+// the channel drivers not shipped with the Asterisk version are not polled, both are until the version is known.
+// A channel driver not loaded in asterisk answers with an error which is only logged as debug
+func PollEndpoints(client *statsd.Statsd, amiClient *ami.Client, timeout time.Duration) {
+	pjsip, sip := endpointDrivers(GetAsteriskVersion())
+
+	if pjsip {
+		if events, err := amiClient.ActionList("PJSIPShowEndpoints", nil, timeout); err != nil {
+			logging.Debug.Println("PJSIPShowEndpoints:", err)
+		} else {
+			updateEndpoints(client, events, "EndpointList", pjsipEndpointStatus)
+		}
+	}
+
+	if sip {
+		if events, err := amiClient.ActionList("SIPpeers", nil, timeout); err != nil {
+			logging.Debug.Println("SIPpeers:", err)
+		} else {
+			updateEndpoints(client, events, "PeerEntry", sipPeerStatus)
+		}
+	}
+}
+
+// endpointDrivers return whether chan_pjsip (since Asterisk 12) and chan_sip (removed in Asterisk 21) can be polled
+func endpointDrivers(version asterisk.Version) (bool, bool) {
+	if !version.IsKnown() {
+		return true, true
+	}
+	return version.AtLeast(12, 0), !version.AtLeast(21, 0)
+}
+
+func updateEndpoints(client *statsd.Statsd, events []*ami.Event, eventID string,
+	status func(func(string, string) string) (string, bool, bool, int64)) {
+
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	for _, event := range events {
+		if event.ID != eventID {
+			continue
+		}
+		device, registered, reachable, roundTrip := status(mapGetter(event.Params))
+		if device == "" {
+			continue
+		}
+
+		endpoint := getEndpoint(device, true)
+		endpoint.Update(registered, reachable, roundTrip)
+		publishEndpoint(client, endpoint, endpointTags(endpoint))
+	}
+}
+
+// pjsipEndpointStatus return the status of a PJSIPShowEndpoints EndpointList event
+func pjsipEndpointStatus(get func(string, string) string) (string, bool, bool, int64) {
+	name := get("ObjectName", "")
+	if name == "" {
+		return "", false, false, -1
+	}
+	registered := get("Contacts", "") != ""
+	deviceState := strings.ToLower(get("DeviceState", ""))
+	reachable := registered && deviceState != "unavailable" && deviceState != "invalid"
+	return "PJSIP/" + name, registered, reachable, -1
+}
+
+// sipPeerStatus return the status of a SIPpeers PeerEntry event
+func sipPeerStatus(get func(string, string) string) (string, bool, bool, int64) {
+	name := get("ObjectName", "")
+	if name == "" {
+		return "", false, false, -1
+	}
+	technology := get("Channeltype", "SIP")
+	registered := get("IPaddress", "-none-") != "-none-"
+
+	status := get("Status", "")
+	if values := regexpPeerStatus.FindStringSubmatch(status); values != nil {
+		roundTrip, _ := strconv.ParseInt(values[2], 10, 64)
+		return technology + "/" + name, registered, true, roundTrip
+	}
+	return technology + "/" + name, registered, registered && status == "Unmonitored", -1
+}
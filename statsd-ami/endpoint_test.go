@@ -0,0 +1,55 @@
+package statsdami
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollEndpointsVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.HandleAction("PJSIPShowEndpoints", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("EndpointListComplete")
+	})
+	server.HandleAction("SIPpeers", func(action amitest.Frame) []amitest.Frame {
+		return amitest.EventList("PeerlistComplete")
+	})
+
+	amiClient := ami.New(server.Addr(), "admin", "secret")
+	if err := amiClient.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go amiClient.Run()
+	defer amiClient.Close()
+
+	// polled return the endpoint actions sent by PollEndpoints with the given Asterisk version
+	polled := func(version string) []string {
+		systemMutex.Lock()
+		system.Version = asterisk.ParseVersion(version)
+		systemMutex.Unlock()
+
+		sent := len(server.Actions())
+		PollEndpoints(nil, amiClient, time.Second)
+		actions := []string{}
+		for _, action := range server.Actions()[sent:] {
+			actions = append(actions, action.Get("Action"))
+		}
+		return actions
+	}
+	defer polled("")
+
+	assert.Equal([]string{"PJSIPShowEndpoints", "SIPpeers"}, polled(""), "unknown version should poll both drivers")
+	assert.Equal([]string{"SIPpeers"}, polled("11.25.3"), "PJSIP polled before Asterisk 12")
+	assert.Equal([]string{"PJSIPShowEndpoints", "SIPpeers"}, polled("16.30.0"), "both drivers should be polled")
+	assert.Equal([]string{"PJSIPShowEndpoints"}, polled("21.0.0"), "SIP polled since Asterisk 21")
+}
@@ -0,0 +1,203 @@
+package statsdami
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/alerting"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+
+	"github.com/quipo/statsd"
+)
+
+// FraudRules toll fraud detectors settings, a zero value disables the detector
+//
+//	Prefixes: premium / international destination prefixes
+//	BurstCalls, BurstWindow: maximum outbound calls per account code in BurstWindow seconds
+//	MaxConcurrent: maximum concurrent outbound calls per account code
+//	Hours: [from, to[ hours during which outbound calls are usual, [8, 20] for 8:00 to 19:59
+//	Hangup: hang the suspect channels up with an AMI Hangup action
+type FraudRules struct {
+	Prefixes      []string `json:"prefixes"`
+	BurstCalls    int      `json:"burst_calls"`
+	BurstWindow   int      `json:"burst_window"`
+	MaxConcurrent int      `json:"max_concurrent"`
+	Hours         []int    `json:"hours"`
+	Hangup        bool     `json:"hangup"`
+}
+
+// FraudDetector check outbound calls against FraudRules
+type FraudDetector struct {
+	rules     FraudRules
+	engine    *alerting.Engine
+	amiClient *ami.Client
+
+	mutex  *sync.Mutex
+	bursts map[string][]burstCall
+}
+
+// burstCall an outbound call recorded in the burst window of its account code
+type burstCall struct {
+	uniqueID string
+	at       time.Time
+}
+
+var fraudMutex = new(sync.RWMutex)
+var fraudDetector *FraudDetector
+
+// NewFraudDetector create a FraudDetector, engine and amiClient may be nil to disable alerts and hangups
+func NewFraudDetector(rules FraudRules, engine *alerting.Engine, amiClient *ami.Client) (*FraudDetector, error) {
+	if len(rules.Hours) != 0 && len(rules.Hours) != 2 {
+		return nil, fmt.Errorf("fraud hours must be [from, to], got %v", rules.Hours)
+	}
+	if rules.BurstCalls > 0 && rules.BurstWindow <= 0 {
+		return nil, fmt.Errorf("fraud burst_window must be set with burst_calls")
+	}
+
+	return &FraudDetector{
+		rules:     rules,
+		engine:    engine,
+		amiClient: amiClient,
+		mutex:     new(sync.Mutex),
+		bursts:    make(map[string][]burstCall),
+	}, nil
+}
+
+// SetFraudDetector set the detector checking new outbound calls, nil to disable it
+func SetFraudDetector(detector *FraudDetector) {
+	fraudMutex.Lock()
+	defer fraudMutex.Unlock()
+	fraudDetector = detector
+}
+
+func getFraudDetector() *FraudDetector {
+	fraudMutex.RLock()
+	defer fraudMutex.RUnlock()
+	return fraudDetector
+}
+
+// Check return the detectors triggered by an outbound call
+func (d *FraudDetector) Check(call *asterisk.Call) []string {
+	if call.Direction != asterisk.DirectionOutbound {
+		return nil
+	}
+	detections := make([]string, 0)
+
+	for _, prefix := range d.rules.Prefixes {
+		if strings.HasPrefix(call.Destination, prefix) {
+			detections = append(detections, "prefix")
+			break
+		}
+	}
+
+	detections = append(detections, d.CheckAccount(call)...)
+
+	if len(d.rules.Hours) == 2 {
+		hour := call.CreatedAt.Hour()
+		if hour < d.rules.Hours[0] || hour >= d.rules.Hours[1] {
+			detections = append(detections, "hours")
+		}
+	}
+	return detections
+}
+
+// CheckAccount return the detectors depending on the account code triggered by an outbound call,
+// for a call whose account code was set after it was created. A call without account code is not checked:
+// it is usually set after the channel is created
+func (d *FraudDetector) CheckAccount(call *asterisk.Call) []string {
+	if call.Direction != asterisk.DirectionOutbound || call.AccountCode == "" {
+		return nil
+	}
+	detections := make([]string, 0)
+
+	if d.rules.BurstCalls > 0 && d.burst(call) > d.rules.BurstCalls {
+		detections = append(detections, "burst")
+	}
+
+	if d.rules.MaxConcurrent > 0 && concurrentOutbound(call.AccountCode) > d.rules.MaxConcurrent {
+		detections = append(detections, "concurrent")
+	}
+	return detections
+}
+
+// burst record an outbound call under its account code and return the number of calls of the account
+// in the burst window. A call recorded again is moved to its new account code, the accounts without calls
+// in the window are forgotten
+func (d *FraudDetector) burst(call *asterisk.Call) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	since := call.CreatedAt.Add(-time.Duration(d.rules.BurstWindow) * time.Second)
+	for key, recorded := range d.bursts {
+		calls := recorded[:0]
+		for _, c := range recorded {
+			if c.at.After(since) && c.uniqueID != call.UniqueID {
+				calls = append(calls, c)
+			}
+		}
+		if len(calls) == 0 {
+			delete(d.bursts, key)
+		} else {
+			d.bursts[key] = calls
+		}
+	}
+	account := call.AccountCode
+	d.bursts[account] = append(d.bursts[account], burstCall{uniqueID: call.UniqueID, at: call.CreatedAt})
+	return len(d.bursts[account])
+}
+
+// concurrentOutbound return the number of outbound calls in progress for a non empty account
+func concurrentOutbound(account string) int {
+	count := 0
+	forEachCall(func(call *asterisk.Call) {
+		if call.Direction == asterisk.DirectionOutbound && call.AccountCode == account {
+			count++
+		}
+	})
+	return count
+}
+
+// checkFraud run the detectors of check on a call, count, alert and hang it up if suspect
+func checkFraud(client *statsd.Statsd, call *asterisk.Call, tags map[string]string,
+	check func(*FraudDetector, *asterisk.Call) []string) {
+
+	detector := getFraudDetector()
+	if detector == nil {
+		return
+	}
+
+	detections := check(detector, call)
+	if len(detections) == 0 {
+		return
+	}
+
+	account := accountTag(call)
+	logging.Warning.Println("fraud suspect", call.UniqueID, call.Channel, "to", call.Destination,
+		"account", account, "detectors", detections)
+
+	for _, detection := range detections {
+		if client != nil {
+			NewMeasure(client, "fraud_suspect", tags).
+				Tag("detector", detection).
+				Tag("account", account).
+				IncrementCounter()
+		}
+		if detector.engine != nil {
+			detector.engine.Raise("fraud_"+detection, account, 1,
+				fmt.Sprintf("fraud suspect (%s): %s calling %s with account %s", detection, call.Channel, call.Destination, account))
+		}
+	}
+
+	if detector.rules.Hangup && detector.amiClient != nil {
+		// do not hold the event handlers while waiting for the response
+		go func(channel string) {
+			if _, err := detector.amiClient.Action("Hangup", ami.Params{"Channel": channel, "Cause": "21"}); err != nil {
+				logging.Error.Println("could not hang up", channel, ":", err)
+			}
+		}(call.Channel)
+	}
+}
@@ -0,0 +1,104 @@
+package statsdami
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func outboundCall(destination string, account string, at time.Time) *asterisk.Call {
+	call := asterisk.NewCall("1001", destination, "uniqueId-"+destination, "SIP/provider-0000abcd", "from-internal")
+	call.Direction = asterisk.DirectionOutbound
+	call.AccountCode = account
+	call.CreatedAt = at
+	return call
+}
+
+func TestNewFraudDetectorRejectInvalidRules(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewFraudDetector(FraudRules{Hours: []int{8}}, nil, nil)
+	assert.NotNil(err, "hours must be [from, to]")
+
+	_, err = NewFraudDetector(FraudRules{BurstCalls: 10}, nil, nil)
+	assert.NotNil(err, "burst_window must be set")
+}
+
+func TestFraudDetectorPrefixesAndHours(t *testing.T) {
+	assert := assert.New(t)
+	detector, _ := NewFraudDetector(FraudRules{Prefixes: []string{"00882", "0899"}, Hours: []int{8, 20}}, nil, nil)
+	noon := time.Date(2017, 6, 27, 12, 0, 0, 0, time.Local)
+	night := time.Date(2017, 6, 27, 3, 0, 0, 0, time.Local)
+
+	assert.Empty(detector.Check(outboundCall("0123456789", "1234", noon)), "usual call must not be suspect")
+	assert.Equal([]string{"prefix"}, detector.Check(outboundCall("0899123456", "1234", noon)), "premium prefix not detected")
+	assert.Equal([]string{"hours"}, detector.Check(outboundCall("0123456789", "1234", night)), "unusual hour not detected")
+
+	inbound := outboundCall("0899123456", "1234", night)
+	inbound.Direction = asterisk.DirectionInbound
+	assert.Empty(detector.Check(inbound), "inbound calls must not be checked")
+}
+
+func TestFraudDetectorBurst(t *testing.T) {
+	assert := assert.New(t)
+	detector, _ := NewFraudDetector(FraudRules{BurstCalls: 2, BurstWindow: 60}, nil, nil)
+	now := time.Now()
+
+	assert.Empty(detector.Check(outboundCall("0123456781", "1234", now)), "1st call must not be suspect")
+	assert.Empty(detector.Check(outboundCall("0123456782", "1234", now)), "2nd call must not be suspect")
+	assert.Empty(detector.Check(outboundCall("0123456783", "5678", now)), "other account must not be suspect")
+	assert.Equal([]string{"burst"}, detector.Check(outboundCall("0123456784", "1234", now)), "burst not detected")
+	assert.Empty(detector.Check(outboundCall("0123456785", "1234", now.Add(2*time.Minute))), "burst window must slide")
+	assert.Equal(1, len(detector.bursts), "accounts without calls in the window must be forgotten")
+}
+
+func TestFraudDetectorWithoutAccount(t *testing.T) {
+	assert := assert.New(t)
+	detector, _ := NewFraudDetector(FraudRules{BurstCalls: 1, BurstWindow: 60, MaxConcurrent: 1}, nil, nil)
+	now := time.Now()
+
+	for _, destination := range []string{"0123456781", "0123456782", "0123456783"} {
+		assert.Empty(detector.Check(outboundCall(destination, "", now)), "calls without account code must not be suspect")
+	}
+	assert.Empty(detector.bursts, "calls without account code must not be recorded")
+
+	call := outboundCall("0123456784", "1234", now)
+	assert.Empty(detector.CheckAccount(call), "1st call of the account must not be suspect")
+	call.AccountCode = "5678"
+	assert.Empty(detector.CheckAccount(call), "a call recorded again must not be suspect")
+	assert.Equal(map[string][]burstCall{"5678": {{uniqueID: call.UniqueID, at: now}}}, detector.bursts,
+		"a call must be recorded once, under its last account code")
+}
+
+func TestFraudCheckedOnNewAccountCode(t *testing.T) {
+	assert := assert.New(t)
+	detector, _ := NewFraudDetector(FraudRules{BurstCalls: 1, BurstWindow: 60}, nil, nil)
+	SetFraudDetector(detector)
+	defer SetFraudDetector(nil)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	for _, id := range []string{"1", "2"} {
+		NewHandler(&client, EventNewChannelHandler)(readEvent(t, "Event: Newchannel\r\n"+
+			"Channel: SIP/1001-0000000"+id+"\r\n"+
+			"CallerIDNum: 1001\r\n"+
+			"Exten: 0123456789\r\n"+
+			"Context: from-internal\r\n"+
+			"Uniqueid: account."+id+"\r\n\r\n"))
+		NewHandler(&client, EventNewAccountCodeHandler)(readEvent(t, "Event: NewAccountCode\r\n"+
+			"Channel: SIP/1001-0000000"+id+"\r\n"+
+			"AccountCode: 4242\r\n"+
+			"Uniqueid: account."+id+"\r\n\r\n"))
+		NewHandler(&client, EventHangupHandler)(readEvent(t, "Event: Hangup\r\n"+
+			"Channel: SIP/1001-0000000"+id+"\r\n"+
+			"Cause: 16\r\n"+
+			"Uniqueid: account."+id+"\r\n\r\n"))
+	}
+
+	assert.Contains(output.String(), "fraud_suspect,account=4242,detector=burst,direction=outbound,trunk=1001:1|c\n",
+		"account code set after the call was created not checked")
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/pgoergler/go-asterisk-statsd/logging"
 
 	"log"
+	"net/textproto"
+	"strconv"
 	"sync"
 
 	"github.com/quipo/statsd"
@@ -13,48 +15,61 @@ import (
 
 type statsdEventHandler func(*statsd.Statsd, *asterisk.Call, *ami.Event, map[string]string)
 
-var callsMutex = new(sync.RWMutex)
-var calls = make(map[string]*asterisk.Call)
+// uniqueIDKeys event params identifying the call when it is not Uniqueid
+var uniqueIDKeys = map[string]string{
+	"BlindTransfer":    "TransfereeUniqueid",
+	"AttendedTransfer": "TransfereeUniqueid",
+}
 
-// GetPendingCallsCount return number of pending calls (not deleted)
-func GetPendingCallsCount() int {
-	callsMutex.Lock()
-	defer callsMutex.Unlock()
-	return len(calls)
+// requiredParams event params without which the event is malformed and not handled
+var requiredParams = map[string][]string{
+	"Newstate": {"Channelstatedesc"},
 }
 
-// Dump pending calls
-func Dump(logger *log.Logger) {
-	callsMutex.Lock()
-	defer callsMutex.Unlock()
-	logger.Println(len(calls), " pending calls")
-	for k, v := range calls {
-		logger.Printf("%s => %v\n", k, v)
-	}
+// bridgesMutex protects bridges and the transfer fields of the calls, updated from the other calls of a bridge
+var bridgesMutex = new(sync.RWMutex)
+var bridges = make(map[string]map[string]*asterisk.Call)
+
+var directionMutex = new(sync.RWMutex)
+var directionClassifier = asterisk.DefaultDirectionClassifier
+
+// SetDirectionClassifier set the classifier used to compute the direction of new calls
+func SetDirectionClassifier(classifier *asterisk.DirectionClassifier) {
+	directionMutex.Lock()
+	defer directionMutex.Unlock()
+	directionClassifier = classifier
 }
 
-func watch(call *asterisk.Call) {
-	callsMutex.Lock()
-	defer callsMutex.Unlock()
-	calls[call.UniqueID] = call
+// GetPendingCallsCount return number of pending calls (not deleted)
+func GetPendingCallsCount() int {
+	count := 0
+	for _, shard := range callShards {
+		shard.mutex.RLock()
+		count += len(shard.calls)
+		shard.mutex.RUnlock()
+	}
+	return count
 }
 
-func unwatch(call *asterisk.Call) {
-	callsMutex.Lock()
-	defer callsMutex.Unlock()
-	delete(calls, call.UniqueID)
+// Dump pending calls
+func Dump(logger *log.Logger) {
+	logger.Println(GetPendingCallsCount(), " pending calls")
+	forEachCall(func(call *asterisk.Call) {
+		logger.Printf("%s => %v\n", call.UniqueID, call)
+	})
 }
 
-func isWatched(uniqueID string) (*asterisk.Call, bool) {
-	callsMutex.Lock()
-	defer callsMutex.Unlock()
-	value, found := calls[uniqueID]
-	return value, found
+// NewPanicCounter return an ami.OnPanic callback counting the handler_panics per event type
+func NewPanicCounter(client *statsd.Statsd) func(*ami.Event, interface{}) {
+	return func(ev *ami.Event, recovered interface{}) {
+		NewMeasure(client, "handler_panics", map[string]string{"event": ev.ID}).IncrementCounter()
+	}
 }
 
+// mapGetter return a getter on event params, keys are canonicalized like the AMI headers
 func mapGetter(params map[string]string) func(string, string) string {
 	return func(key string, defaultValue string) string {
-		value, ok := params[key]
+		value, ok := params[textproto.CanonicalMIMEHeaderKey(key)]
 		if !ok {
 			return defaultValue
 		}
@@ -63,16 +78,32 @@ func mapGetter(params map[string]string) func(string, string) string {
 }
 
 // NewHandler call handler with extra paramters
-//  handler(*statsd.StatsdClient, *asterisk.Call, *ami.Event, map[string]string)
+//
+//	handler(*statsd.StatsdClient, *asterisk.Call, *ami.Event, map[string]string)
 func NewHandler(client *statsd.Statsd, handler statsdEventHandler) func(*ami.Event) {
 	return func(message *ami.Event) {
 		get := mapGetter(message.Params)
 
-		uniqueID := get("Uniqueid", "")
+		uniqueIDKey, ok := uniqueIDKeys[message.ID]
+		if !ok {
+			uniqueIDKey = "Uniqueid"
+		}
+
+		uniqueID := get(uniqueIDKey, "")
 		if uniqueID == "" {
 			logging.Error.Println("no uniqueID found in", message)
 			return
 		}
+		for _, key := range requiredParams[message.ID] {
+			if get(key, "") == "" {
+				logging.Error.Println("no", key, "found in", message)
+				return
+			}
+		}
+
+		shard := shardOf(uniqueID)
+		shard.dispatch.Lock()
+		defer shard.dispatch.Unlock()
 
 		call, found := isWatched(uniqueID)
 		if !found {
@@ -88,34 +119,57 @@ func NewHandler(client *statsd.Statsd, handler statsdEventHandler) func(*ami.Eve
 				uniqueID,
 				get("Channel", "not_set"),
 				get("Context", "not_set"))
+			call.AccountCode = get("AccountCode", "")
+
+			directionMutex.RLock()
+			call.Direction = directionClassifier.Classify(call)
+			directionMutex.RUnlock()
 
 			watch(call)
 		}
 
-		handler(client, call, message, map[string]string{"trunk": call.GetTrunkName()})
-
 		if message.ID == "Hangup" {
-			unwatch(call)
+			// forget the call even if the handler panics
+			defer unwatch(call)
 		}
+
+		handler(client, call, message, callTags(call))
 	}
 }
 
+// callTags return the tags of all the call metrics, the OptionalTags included
+func callTags(call *asterisk.Call) map[string]string {
+	return map[string]string{
+		"trunk":     call.GetTrunkName(),
+		"direction": string(call.Direction),
+		"context":   call.Context,
+		"account":   accountTag(call),
+	}
+}
+
+// accountTag return the account code of a call, "-" if not set
+func accountTag(call *asterisk.Call) string {
+	if call.AccountCode == "" {
+		return "-"
+	}
+	return call.AccountCode
+}
+
 func eventDefaultHandler(client *statsd.Statsd,
 	call *asterisk.Call, message *ami.Event, tags map[string]string) {
 }
 
-//EventNewChannelHandler handle new call
+// EventNewChannelHandler handle new call
 func EventNewChannelHandler(client *statsd.Statsd,
 	call *asterisk.Call, message *ami.Event, tags map[string]string) {
 
+	checkFraud(client, call, tags, (*FraudDetector).Check)
+
 	if client == nil {
 		return
 	}
 	NewMeasure(client, "concurrent", tags).IncrementGauge()
 	NewMeasure(client, "calls", tags).IncrementCounter()
-
-	NewMeasure(client, "concurrent", tags).Tag("trunk", "All").IncrementGauge()
-	NewMeasure(client, "calls", tags).Tag("trunk", "All").IncrementCounter()
 }
 
 // EventNewStateHandler handle Call state changed
@@ -142,7 +196,29 @@ func EventNewAccountCodeHandler(client *statsd.Statsd,
 	call *asterisk.Call, message *ami.Event, tags map[string]string) {
 
 	get := mapGetter(message.Params)
-	call.AccountCode = get("AccountCode", "")
+	account := get("AccountCode", "")
+	if account == call.AccountCode {
+		return
+	}
+
+	// read by the fraud detector while handling other calls
+	shard := shardOf(call.UniqueID)
+	shard.mutex.Lock()
+	call.AccountCode = account
+	shard.mutex.Unlock()
+
+	// the gauges tagged with the account code move to the new one
+	if client != nil && optionalTag("concurrent", "account") {
+		NewMeasure(client, "concurrent", tags).DecrementGauge()
+		NewMeasure(client, "concurrent", callTags(call)).IncrementGauge()
+	}
+	if client != nil && call.IsOnHold() && optionalTag("on_hold", "account") {
+		NewMeasure(client, "on_hold", tags).DecrementGauge()
+		NewMeasure(client, "on_hold", callTags(call)).IncrementGauge()
+	}
+
+	// the account detectors ran with the previous account code
+	checkFraud(client, call, tags, (*FraudDetector).CheckAccount)
 }
 
 // EventSoftHangupHandler handle Call soft hangup
@@ -158,12 +234,31 @@ func EventHangupHandler(client *statsd.Statsd,
 	call *asterisk.Call, message *ami.Event, tags map[string]string) {
 
 	get := mapGetter(message.Params)
+
+	for _, bridge := range call.OpenBridges() {
+		leaveBridge(client, call, bridge.ID, tags)
+	}
+
+	if call.Unhold() && client != nil {
+		NewMeasure(client, "on_hold", tags).DecrementGauge()
+	}
+
 	call.Hangup(get("Cause", ""), get("Cause-Txt", ""))
+	record(call)
 
 	if client == nil {
 		return
 	}
 
+	bridgesMutex.RLock()
+	abandoned := call.AbandonedInTransfer()
+	bridgesMutex.RUnlock()
+	if abandoned {
+		NewMeasure(client, "abandoned_transfers", tags).
+			Tag("type", call.TransferType).
+			IncrementCounter()
+	}
+
 	cause := call.HangupCause
 	if cause == "" {
 		cause = "-"
@@ -175,7 +270,6 @@ func EventHangupHandler(client *statsd.Statsd,
 	}
 
 	NewMeasure(client, "concurrent", tags).DecrementGauge()
-	NewMeasure(client, "concurrent", tags).Tag("trunk", "All").DecrementGauge()
 
 	NewMeasure(client, "active_duration", tags).
 		Tag("cause", cause).
@@ -183,24 +277,184 @@ func EventHangupHandler(client *statsd.Statsd,
 		Tag("disposition", call.Disposition()).
 		Timing(call.ActiveDuration)
 
-	NewMeasure(client, "active_duration", tags).
-		Tag("cause", cause).
-		Tag("cause_txt", causeTxt).
-		Tag("disposition", call.Disposition()).
-		Tag("trunk", "All").
-		Timing(call.ActiveDuration)
-
 	NewMeasure(client, "total_duration", tags).
 		Tag("cause", cause).
 		Tag("cause_txt", causeTxt).
 		Tag("disposition", call.Disposition()).
 		Timing(call.TotalDuration)
 
-	NewMeasure(client, "total_duration", tags).
-		Tag("cause", cause).
-		Tag("cause_txt", causeTxt).
-		Tag("disposition", call.Disposition()).
-		Tag("trunk", "All").
-		Timing(call.TotalDuration)
+	if call.Quality.Reports > 0 {
+		quality := map[string]int64{
+			"rtcp_jitter":     int64(call.Quality.Jitter()),
+			"rtcp_jitter_max": int64(call.Quality.JitterMax),
+			"rtcp_loss":       int64(call.Quality.Loss() * 100),
+			"rtcp_rtt":        int64(call.Quality.RTT()),
+			"mos":             int64(call.Quality.MOS() * 100),
+		}
+		for name, value := range quality {
+			NewMeasure(client, name, tags).Timing(value)
+		}
+	}
+
+	if call.HoldCount > 0 {
+		NewMeasure(client, "hold_count", tags).
+			Tag("cause", cause).
+			Tag("cause_txt", causeTxt).
+			Tag("disposition", call.Disposition()).
+			Timing(call.HoldCount)
+
+		NewMeasure(client, "hold_duration", tags).
+			Tag("cause", cause).
+			Tag("cause_txt", causeTxt).
+			Tag("disposition", call.Disposition()).
+			Timing(call.HoldDuration)
+	}
+
+}
+
+// EventHoldHandler handle Call put on hold (Hold and MusicOnHoldStart)
+func EventHoldHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	if !call.Hold() || client == nil {
+		return
+	}
+	NewMeasure(client, "on_hold", tags).IncrementGauge()
+}
+
+// EventUnholdHandler handle Call taken off hold (Unhold and MusicOnHoldStop)
+func EventUnholdHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	if !call.Unhold() || client == nil {
+		return
+	}
+	NewMeasure(client, "on_hold", tags).DecrementGauge()
+}
+
+// rtcpClockRate RTP clock rate used to convert RTCP jitter to ms (8kHz for G.711, G.729, GSM...)
+const rtcpClockRate = 8000
+
+// EventRTCPHandler handle RTCP reports (RTCPSent and RTCPReceived)
+func EventRTCPHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	count, _ := strconv.Atoi(get("ReportCount", "0"))
+
+	// RTT is only computed on received reports, in seconds
+	rtt := float64(-1)
+	if value, err := strconv.ParseFloat(get("RTT", ""), 64); err == nil {
+		rtt = value * 1000
+	}
+
+	for i := 0; i < count; i++ {
+		prefix := "Report" + strconv.Itoa(i)
+		jitter, _ := strconv.ParseFloat(get(prefix+"IAJitter", "0"), 64)
+		fractionLost, _ := strconv.ParseFloat(get(prefix+"FractionLost", "0"), 64)
+		cumulativeLost, _ := strconv.ParseInt(get(prefix+"CumulativeLost", "0"), 10, 64)
+
+		call.Quality.AddReport(asterisk.RTCPReport{
+			Jitter:         jitter * 1000 / rtcpClockRate,
+			FractionLost:   fractionLost * 100 / 256,
+			CumulativeLost: cumulativeLost,
+			RTT:            rtt,
+		})
+	}
+}
+
+// EventBridgeEnterHandler handle Call entering a bridge
+func EventBridgeEnterHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	bridgeID := get("BridgeUniqueid", "")
+	call.BridgeEnter(bridgeID)
+
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	members, found := bridges[bridgeID]
+	if !found {
+		members = make(map[string]*asterisk.Call)
+		bridges[bridgeID] = members
+	}
+
+	// a transferred call is connected as soon as it shares a bridge with another channel
+	for _, member := range members {
+		member.TransferConnected()
+		call.TransferConnected()
+	}
+	members[call.UniqueID] = call
+}
+
+// EventBridgeLeaveHandler handle Call leaving a bridge
+func EventBridgeLeaveHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	leaveBridge(client, call, get("BridgeUniqueid", ""), tags)
+}
+
+func leaveBridge(client *statsd.Statsd, call *asterisk.Call, bridgeID string, tags map[string]string) {
+	bridgesMutex.Lock()
+	if members, found := bridges[bridgeID]; found {
+		delete(members, call.UniqueID)
+		if len(members) == 0 {
+			delete(bridges, bridgeID)
+		}
+	}
+	bridgesMutex.Unlock()
+
+	bridge := call.BridgeLeave(bridgeID)
+	if bridge == nil || client == nil {
+		return
+	}
+
+	NewMeasure(client, "bridged_duration", tags).Timing(bridge.Duration())
+}
+
+// EventBlindTransferHandler handle transferee Call blind transferred
+func EventBlindTransferHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	result := get("Result", "")
+	if result == "Success" {
+		bridgesMutex.Lock()
+		call.Transferred("blind", get("Extension", "")+"@"+get("Context", ""))
+		bridgesMutex.Unlock()
+	}
+	countTransfer(client, "blind", result, tags)
+}
+
+// EventAttendedTransferHandler handle transferee Call attended transferred
+func EventAttendedTransferHandler(client *statsd.Statsd,
+	call *asterisk.Call, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	result := get("Result", "")
+	if result == "Success" {
+		target := get("TransferTargetChannel", "")
+		if target == "" {
+			target = get("DestApp", "")
+		}
+		bridgesMutex.Lock()
+		call.Transferred("attended", target)
+		bridgesMutex.Unlock()
+	}
+	countTransfer(client, "attended", result, tags)
+}
+
+func countTransfer(client *statsd.Statsd, transferType string, result string, tags map[string]string) {
+	if client == nil {
+		return
+	}
+	if result == "" {
+		result = "-"
+	}
 
+	NewMeasure(client, "transfers", tags).
+		Tag("type", transferType).
+		Tag("result", result).
+		IncrementCounter()
 }
@@ -0,0 +1,202 @@
+package statsdami
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent parse a raw AMI frame the way the AMI client does
+func readEvent(t *testing.T, frame string) *ami.Event {
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(frame))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &ami.Event{ID: header.Get("Event"), Privilege: strings.Split(header.Get("Privilege"), ","), Params: make(map[string]string)}
+	for k, v := range header {
+		if k != "Event" && k != "Privilege" {
+			ev.Params[k] = v[0]
+		}
+	}
+	return ev
+}
+
+func TestNewChannelFrameDirection(t *testing.T) {
+	assert := assert.New(t)
+
+	NewHandler(nil, EventNewChannelHandler)(readEvent(t, "Event: Newchannel\r\n"+
+		"Privilege: call,all\r\n"+
+		"Channel: SIP/provider-00000042\r\n"+
+		"ChannelState: 0\r\n"+
+		"ChannelStateDesc: Down\r\n"+
+		"CallerIDNum: 0611223344\r\n"+
+		"CallerIDName: \r\n"+
+		"Exten: 100\r\n"+
+		"Context: from-trunk\r\n"+
+		"Uniqueid: frame.1\r\n"+
+		"\r\n"))
+
+	call, watched := isWatched("frame.1")
+	if !assert.True(watched, "call not watched") {
+		return
+	}
+	defer unwatch(call)
+	assert.Equal("0611223344", call.Source, "source not read from CallerIDNum")
+	assert.Equal("100", call.Destination, "destination not read from Exten")
+	assert.Equal(asterisk.DirectionInbound, call.Direction, "direction not classified")
+}
+
+func TestHangupHandlerPanicUnwatch(t *testing.T) {
+	assert := assert.New(t)
+
+	NewHandler(nil, EventNewChannelHandler)(readEvent(t, "Event: Newchannel\r\n"+
+		"Channel: SIP/provider-00000043\r\n"+
+		"Uniqueid: panic.1\r\n\r\n"))
+	hangup := NewHandler(nil, func(client *statsd.Statsd, call *asterisk.Call, message *ami.Event, tags map[string]string) {
+		panic("handler bug")
+	})
+	assert.Panics(func() {
+		hangup(readEvent(t, "Event: Hangup\r\nChannel: SIP/provider-00000043\r\nUniqueid: panic.1\r\n\r\n"))
+	})
+
+	call, watched := isWatched("panic.1")
+	if watched {
+		unwatch(call)
+	}
+	assert.False(watched, "call still watched after its Hangup handler panicked")
+	// the shard must be released too
+	shard := shardOf("panic.1")
+	shard.dispatch.Lock()
+	shard.dispatch.Unlock()
+}
+
+func TestMalformedEventsSkipped(t *testing.T) {
+	assert := assert.New(t)
+
+	NewHandler(nil, EventNewChannelHandler)(readEvent(t, "Event: Newchannel\r\n"+
+		"Channel: SIP/provider-00000045\r\n"+
+		"Uniqueid: malformed.1\r\n\r\n"))
+	call, watched := isWatched("malformed.1")
+	if !assert.True(watched, "call not watched") {
+		return
+	}
+	defer unwatch(call)
+
+	handled := make([]string, 0)
+	newstate := NewHandler(nil, func(client *statsd.Statsd, call *asterisk.Call, message *ami.Event, tags map[string]string) {
+		handled = append(handled, message.Params["Uniqueid"])
+		EventNewStateHandler(client, call, message, tags)
+	})
+	newstate(readEvent(t, "Event: Newstate\r\nChannel: SIP/provider-00000045\r\nChannelStateDesc: Up\r\n\r\n"))
+	newstate(readEvent(t, "Event: Newstate\r\nChannel: SIP/provider-00000045\r\nUniqueid: malformed.1\r\n\r\n"))
+	newstate(readEvent(t, "Event: Newstate\r\nChannel: SIP/provider-00000045\r\nChannelStateDesc: \r\nUniqueid: malformed.1\r\n\r\n"))
+	assert.Empty(handled, "malformed events should not be handled")
+	assert.True(call.AnsweredAt.IsZero(), "call state changed by a malformed event")
+
+	newstate(readEvent(t, "Event: Newstate\r\nChannel: SIP/provider-00000045\r\nChannelStateDesc: Up\r\nUniqueid: malformed.1\r\n\r\n"))
+	assert.Equal([]string{"malformed.1"}, handled, "well formed event not handled")
+	assert.False(call.AnsweredAt.IsZero(), "call state not changed")
+}
+
+func TestNewAccountCodeMovesGauges(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(SetRollups(map[string]RollupConfig{"concurrent": {Tags: []string{"account"}, Backend: true}}))
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	NewHandler(&client, EventNewChannelHandler)(readEvent(t, "Event: Newchannel\r\n"+
+		"Channel: SIP/provider-00000044\r\n"+
+		"CallerIDNum: 0611223344\r\n"+
+		"Exten: 100\r\n"+
+		"Context: from-trunk\r\n"+
+		"Uniqueid: account.move\r\n\r\n"))
+	defer func() {
+		if call, found := isWatched("account.move"); found {
+			unwatch(call)
+		}
+	}()
+	NewHandler(&client, EventNewAccountCodeHandler)(readEvent(t, "Event: NewAccountCode\r\n"+
+		"Channel: SIP/provider-00000044\r\n"+
+		"AccountCode: 4242\r\n"+
+		"Uniqueid: account.move\r\n\r\n"))
+
+	metrics := output.String()
+	assert.Contains(metrics, "concurrent,account=-,direction=inbound,trunk=provider:-1|g\n",
+		"call not removed from the concurrent calls of its previous account code")
+	assert.Contains(metrics, "concurrent,account=4242,direction=inbound,trunk=provider:+1|g\n",
+		"call not added to the concurrent calls of its account code")
+}
+
+func TestCallMetricsEndToEnd(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, "pbx."))
+
+	amiClient := ami.New(server.Addr(), "admin", "secret", ami.StrictHandlers)
+	amiClient.RegisterHandler("Newchannel", NewHandler(&client, EventNewChannelHandler))
+	amiClient.RegisterHandler("Newstate", NewHandler(&client, EventNewStateHandler))
+	amiClient.RegisterHandler("SoftHangupRequest", NewHandler(&client, EventSoftHangupHandler))
+	amiClient.RegisterHandler("Hangup", NewHandler(&client, EventHangupHandler))
+	if err := amiClient.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go amiClient.Run()
+	defer amiClient.Close()
+
+	channel := amitest.Frame{"Channel": "SIP/provider-00000042", "Uniqueid": "e2e.1", "Context": "from-trunk"}
+	newchannel := amitest.Frame{"Event": "Newchannel", "CallerIDNum": "0611223344", "Exten": "100"}
+	newstate := amitest.Frame{"Event": "Newstate", "ChannelStateDesc": "Up"}
+	softhangup := amitest.Frame{"Event": "SoftHangupRequest", "Cause": "16"}
+	hangup := amitest.Frame{"Event": "Hangup", "Cause": "16", "Cause-txt": "Normal Clearing"}
+	// handled wait until the pushed events are handled: events are read in order,
+	// once the ping is answered they have all been queued
+	handled := func() {
+		_, err := amiClient.Ping()
+		assert.Nil(err)
+		amiClient.Flush()
+	}
+	for _, ev := range []amitest.Frame{newchannel, newstate, softhangup, hangup} {
+		for k, v := range channel {
+			ev[k] = v
+		}
+		assert.Nil(server.Push(ev))
+		if ev["Event"] == "Newstate" {
+			// durations are in ms, a call answered for less than 1ms is not answered:
+			// the answer is handled before the talk time starts
+			handled()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	handled()
+
+	metrics := output.String()
+	assert.Contains(metrics, "pbx.calls,direction=inbound,trunk=provider:1|c\n", "calls not counted")
+	assert.Contains(metrics, "pbx.concurrent,direction=inbound,trunk=provider:+1|g\n", "concurrent not incremented")
+	assert.Contains(metrics, "pbx.concurrent,direction=inbound,trunk=provider:-1|g\n", "concurrent not decremented")
+	assert.Contains(metrics, "pbx.total_duration,cause=16,cause_txt=Normal Clearing,direction=inbound,disposition=ANSWERED,trunk=provider:",
+		"total duration not emitted")
+	_, watched := isWatched("e2e.1")
+	assert.False(watched, "call should be forgotten once hung up")
+
+	server.SetResponseDelay(10 * time.Millisecond)
+	rtt, err := amiClient.Ping()
+	assert.Nil(err)
+	assert.True(rtt >= 10*time.Millisecond, "ping rtt not correctly measured")
+}
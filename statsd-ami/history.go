@@ -0,0 +1,149 @@
+package statsdami
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+)
+
+// CallRecord summary of a finished call
+type CallRecord struct {
+	HangupAt       time.Time
+	Trunk          string
+	Direction      string
+	Disposition    string
+	Cause          string
+	ActiveDuration int64
+}
+
+// WindowStats statistics of the calls finished in a time window
+type WindowStats struct {
+	Calls          int64
+	Answered       int64
+	Effective      int64
+	ActiveDuration int64
+	Causes         map[string]int64
+}
+
+// effectiveCauses hangup causes of calls which reached the destination (NER)
+//
+//	16 normal clearing, 17 user busy, 18 no user responding, 19 no answer, 21 call rejected
+var effectiveCauses = map[string]bool{"16": true, "17": true, "18": true, "19": true, "21": true}
+
+// HistoryRetention how long finished calls are kept to compute statistics
+var HistoryRetention = time.Hour
+
+var historyMutex = new(sync.RWMutex)
+var history = make([]CallRecord, 0)
+
+// GetHistoryCount return number of finished calls kept
+func GetHistoryCount() int {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+	return len(history)
+}
+
+// DumpHistory dump the statistics of the finished calls kept
+func DumpHistory(logger *log.Logger) {
+	stats := GetWindowStats(HistoryRetention)
+	logger.Println(len(stats), " history trunks")
+	for k, v := range stats {
+		logger.Printf("%s => %v\n", k, v)
+	}
+}
+
+// record keep a summary of a finished call
+func record(call *asterisk.Call) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	now := time.Now()
+	history = append(history, CallRecord{
+		HangupAt:       now,
+		Trunk:          call.GetTrunkName(),
+		Direction:      string(call.Direction),
+		Disposition:    call.Disposition(),
+		Cause:          call.HangupCause,
+		ActiveDuration: call.ActiveDuration,
+	})
+
+	// history is ordered by HangupAt
+	expired := 0
+	for expired < len(history) && now.Sub(history[expired].HangupAt) > HistoryRetention {
+		expired++
+	}
+	if expired > 0 {
+		history = append(history[:0], history[expired:]...)
+	}
+}
+
+func newWindowStats() *WindowStats {
+	return &WindowStats{Causes: make(map[string]int64)}
+}
+
+func (s *WindowStats) add(r *CallRecord) {
+	s.Calls++
+	if r.Disposition == "ANSWERED" {
+		s.Answered++
+		s.ActiveDuration += r.ActiveDuration
+	}
+	if r.Disposition == "ANSWERED" || effectiveCauses[r.Cause] {
+		s.Effective++
+	}
+	s.Causes[r.Cause]++
+}
+
+// ASR answer seizure ratio: percentage of answered calls
+func (s *WindowStats) ASR() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Answered) * 100 / float64(s.Calls)
+}
+
+// ACD average call duration: average active duration of answered calls in seconds
+func (s *WindowStats) ACD() float64 {
+	if s.Answered == 0 {
+		return 0
+	}
+	return float64(s.ActiveDuration) / 1000 / float64(s.Answered)
+}
+
+// NER network effectiveness ratio: percentage of calls which reached the destination
+// (answered, busy, no answer or rejected by the user)
+func (s *WindowStats) NER() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Effective) * 100 / float64(s.Calls)
+}
+
+// GetWindowStats return the statistics of the calls finished during the last window, per trunk and for "All"
+func GetWindowStats(window time.Duration) map[string]*WindowStats {
+	stats := map[string]*WindowStats{"All": newWindowStats()}
+	groupWindowStats(window, stats, func(r *CallRecord) []string {
+		return []string{r.Trunk, "All"}
+	})
+	return stats
+}
+
+// groupWindowStats add the calls finished during the last window to the stats of the keys returned by group
+func groupWindowStats(window time.Duration, stats map[string]*WindowStats, group func(*CallRecord) []string) {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+
+	since := time.Now().Add(-window)
+	for i := len(history) - 1; i >= 0 && history[i].HangupAt.After(since); i-- {
+		r := &history[i]
+		for _, key := range group(r) {
+			s, found := stats[key]
+			if !found {
+				s = newWindowStats()
+				stats[key] = s
+			}
+			s.add(r)
+		}
+	}
+}
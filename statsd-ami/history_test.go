@@ -0,0 +1,49 @@
+package statsdami
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/stretchr/testify/assert"
+)
+
+func finishedCall(channel string, cause string, activeDuration int64) *asterisk.Call {
+	call := asterisk.NewCall("1001", "0123456789", "uniqueId", channel, "context")
+	call.Direction = asterisk.DirectionOutbound
+	call.Hangup(cause, "")
+	call.ActiveDuration = activeDuration
+	return call
+}
+
+func TestWindowStats(t *testing.T) {
+	assert := assert.New(t)
+	history = make([]CallRecord, 0)
+
+	record(finishedCall("SIP/provider-0000abcd", "16", 60000))
+	record(finishedCall("SIP/provider-0000abcd", "16", 120000))
+	record(finishedCall("SIP/provider-0000abcd", "17", 0))
+	record(finishedCall("SIP/provider-0000abcd", "34", 0))
+	record(finishedCall("SIP/other-0000abcd", "38", 0))
+
+	stats := GetWindowStats(time.Minute)
+	provider := stats["provider"]
+	assert.Equal(int64(4), provider.Calls, "wrong provider calls")
+	assert.Equal(float64(50), provider.ASR(), "wrong provider ASR")
+	assert.Equal(float64(90), provider.ACD(), "wrong provider ACD")
+	assert.Equal(float64(75), provider.NER(), "wrong provider NER")
+	assert.Equal(int64(1), provider.Causes["34"], "wrong provider cause 34 count")
+
+	assert.Equal(int64(5), stats["All"].Calls, "wrong All calls")
+	assert.Equal(float64(40), stats["All"].ASR(), "wrong All ASR")
+	assert.Equal(float64(0), stats["other"].NER(), "wrong other NER")
+}
+
+func TestWindowStatsExpire(t *testing.T) {
+	assert := assert.New(t)
+	history = []CallRecord{{HangupAt: time.Now().Add(-2 * time.Hour), Trunk: "provider"}}
+
+	record(finishedCall("SIP/provider-0000abcd", "16", 60000))
+	assert.Equal(1, GetHistoryCount(), "expired call must be removed")
+	assert.Equal(int64(1), GetWindowStats(time.Hour)["provider"].Calls, "wrong provider calls")
+}
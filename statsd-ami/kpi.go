@@ -0,0 +1,104 @@
+package statsdami
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quipo/statsd"
+)
+
+// KPIWindows sliding windows over which the KPIs are computed, must not exceed HistoryRetention
+var KPIWindows = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+}
+
+// kpis value of each KPI gauge computed from the stats of a window
+var kpis = map[string]func(*WindowStats) int64{
+	"kpi_calls": func(s *WindowStats) int64 { return s.Calls },
+	"kpi_asr":   func(s *WindowStats) int64 { return int64(s.ASR()) },
+	"kpi_acd":   func(s *WindowStats) int64 { return int64(s.ACD()) },
+	"kpi_ner":   func(s *WindowStats) int64 { return int64(s.NER()) },
+}
+
+var kpiMutex = new(sync.Mutex)
+
+// publishedKPIs trunk / direction keys published by the last PublishKPIs, by KPI
+var publishedKPIs = make(map[string]map[string]bool)
+
+// kpiKey return the key of trunk and direction tags
+func kpiKey(tags map[string]string) string {
+	return tags["trunk"] + "|" + tags["direction"]
+}
+
+// PublishKPIs publish ASR, ACD and NER gauges per trunk and direction for each KPIWindows,
+// each KPI is rolled up by its rollup config
+//
+// a window without finished calls publishes 0 for all of them, the gauges do not keep the last value
+func PublishKPIs(client *statsd.Statsd) {
+	if client == nil {
+		return
+	}
+
+	kpiMutex.Lock()
+	defer kpiMutex.Unlock()
+
+	configs := make(map[string]RollupConfig, len(kpis))
+	keys := make(map[string]map[string]bool, len(kpis))
+	for metric := range kpis {
+		configs[metric] = getRollup(metric)
+		keys[metric] = make(map[string]bool)
+	}
+
+	// keys of the KPIs of a call: its trunk and direction, and their rollups
+	group := func(r *CallRecord) []string {
+		tags := map[string]string{"trunk": r.Trunk, "direction": r.Direction}
+		grouped := make([]string, 0, 2)
+		seen := make(map[string]bool, 2)
+		for metric, config := range configs {
+			for _, t := range append([]map[string]string{tags}, rollupTags(config, tags)...) {
+				key := kpiKey(t)
+				keys[metric][key] = true
+				if !seen[key] {
+					seen[key] = true
+					grouped = append(grouped, key)
+				}
+			}
+		}
+		return grouped
+	}
+
+	// keys of the widest window, the narrower ones report them with 0 calls
+	windows := make(map[string]map[string]*WindowStats)
+	for name, window := range KPIWindows {
+		stats := make(map[string]*WindowStats)
+		groupWindowStats(window, stats, group)
+		windows[name] = stats
+	}
+
+	for metric, value := range kpis {
+		published := make(map[string]bool, len(keys[metric]))
+		for key := range keys[metric] {
+			published[key] = true
+		}
+		// the keys without calls anymore are published with 0 calls a last time
+		for key := range publishedKPIs[metric] {
+			keys[metric][key] = true
+		}
+		publishedKPIs[metric] = published
+
+		for name, stats := range windows {
+			for key := range keys[metric] {
+				s, found := stats[key]
+				if !found {
+					s = newWindowStats()
+				}
+				values := strings.SplitN(key, "|", 2)
+				tags := map[string]string{"trunk": values[0], "direction": values[1], "window": name}
+				NewMeasure(client, metric, tags).Gauge(value(s))
+			}
+		}
+	}
+}
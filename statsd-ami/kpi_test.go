@@ -0,0 +1,69 @@
+package statsdami
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishKPIsEmptyWindow(t *testing.T) {
+	assert := assert.New(t)
+	history = []CallRecord{{
+		HangupAt:       time.Now().Add(-2 * time.Minute),
+		Trunk:          "provider",
+		Direction:      "inbound",
+		Disposition:    "ANSWERED",
+		Cause:          "16",
+		ActiveDuration: 60000,
+	}}
+	defer func() {
+		history = make([]CallRecord, 0)
+		publishedKPIs = make(map[string]map[string]bool)
+	}()
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	PublishKPIs(&client)
+
+	metrics := output.String()
+	for _, metric := range []string{"kpi_calls", "kpi_asr", "kpi_acd", "kpi_ner"} {
+		assert.Contains(metrics, metric+",direction=inbound,trunk=provider,window=1m:0|g\n", metric+" not reset in an empty window")
+	}
+	assert.Contains(metrics, "kpi_asr,direction=inbound,trunk=provider,window=5m:100|g\n", "kpi_asr not published")
+	assert.Contains(metrics, "kpi_acd,direction=inbound,trunk=provider,window=5m:60|g\n", "kpi_acd not published")
+}
+
+func TestPublishKPIsRollups(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	history = []CallRecord{
+		{HangupAt: now, Trunk: "provider-a", Direction: "inbound", Disposition: "ANSWERED", Cause: "16", ActiveDuration: 60000},
+		{HangupAt: now, Trunk: "provider-b", Direction: "inbound", Disposition: "NOANSWER", Cause: "19"},
+	}
+	defer func() {
+		history = make([]CallRecord, 0)
+		publishedKPIs = make(map[string]map[string]bool)
+	}()
+	assert.Nil(SetRollups(map[string]RollupConfig{
+		"*":         {Backend: true},
+		"kpi_calls": {Dimensions: [][]string{{"trunk", "direction"}}},
+	}))
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	PublishKPIs(&client)
+
+	metrics := output.String()
+	assert.Contains(metrics, "kpi_calls,direction=inbound,trunk=provider-a,window=1m:1|g\n", "kpi_calls not published")
+	assert.Contains(metrics, "kpi_calls,direction=All,trunk=All,window=1m:2|g\n", "kpi_calls not rolled up")
+	assert.Contains(metrics, "kpi_asr,direction=inbound,trunk=provider-a,window=1m:100|g\n", "answered call not counted in kpi_asr")
+	assert.Contains(metrics, "kpi_asr,direction=inbound,trunk=provider-b,window=1m:0|g\n", "unanswered call counted in kpi_asr")
+	assert.Contains(metrics, "kpi_ner,direction=inbound,trunk=provider-a,window=1m:100|g\n", "answered call not counted in kpi_ner")
+	assert.Contains(metrics, "kpi_ner,direction=inbound,trunk=provider-b,window=1m:100|g\n", "unanswered call not counted in kpi_ner")
+	assert.NotContains(metrics, "trunk=All,window=1m:50|g", "kpi_asr rollups are left to the backend")
+	assert.NotContains(metrics, "direction=inbound,trunk=All", "default rollups should be replaced")
+}
@@ -2,9 +2,9 @@ package statsdami
 
 import (
 	"log"
+	"sort"
 	"sync"
 
-	"github.com/pgoergler/go-asterisk-statsd/logging"
 	"github.com/quipo/statsd"
 )
 
@@ -23,21 +23,21 @@ var gaugesCounter = make(map[string]bool)
 func DumpGauges(logger *log.Logger) {
 	gaugeMutex.Lock()
 	defer gaugeMutex.Unlock()
-	logger.Println(len(calls), " gauge")
+	logger.Println(len(gaugesCounter), " gauge")
 	for k := range gaugesCounter {
 		logger.Printf("%s,", k)
 	}
 	logger.Println("")
 }
 
-//GetGaugeCount return gauge count
+// GetGaugeCount return gauge count
 func GetGaugeCount() int {
 	gaugeMutex.Lock()
 	defer gaugeMutex.Unlock()
 	return len(gaugesCounter)
 }
 
-//GetAllGauges return all gauges
+// GetAllGauges return all gauges
 func GetAllGauges() []string {
 	gaugeMutex.Lock()
 	defer gaugeMutex.Unlock()
@@ -57,8 +57,8 @@ func ResetAllGauges() {
 
 // shouldReset return true if a Gauge should be rested in statsd server
 func shouldResetGauge(aspect string) bool {
-	gaugeMutex.RLock()
-	defer gaugeMutex.RUnlock()
+	gaugeMutex.Lock()
+	defer gaugeMutex.Unlock()
 	value, ok := gaugesCounter[aspect]
 	if ok {
 		return !value
@@ -71,7 +71,7 @@ func registerGauge(aspect string) bool {
 	return true
 }
 
-// NewMeasure build statsd aspect from name and tags
+// NewMeasure build statsd aspect from name and tags, the OptionalTags not enabled for the metric are dropped
 func NewMeasure(client *statsd.Statsd, name string, tags map[string]string) *Measure {
 	m := &Measure{
 		name:   name,
@@ -80,6 +80,9 @@ func NewMeasure(client *statsd.Statsd, name string, tags map[string]string) *Mea
 	}
 
 	for k, v := range tags {
+		if OptionalTags[k] && !optionalTag(name, k) {
+			continue
+		}
 		m.tags[k] = v
 	}
 	return m
@@ -91,59 +94,104 @@ func (m *Measure) Tag(name string, value string) *Measure {
 	return m
 }
 
-// GetAspect return the statsd aspect of the Measure
+// GetAspect return the statsd aspect of the Measure, shaped by the metric catalogue and the cardinality limiter
+// tags are sorted by name so a Measure always has the same aspect
 func (m *Measure) GetAspect() string {
-	aspect := m.name
-	for k, v := range m.tags {
-		aspect += "," + k + "=" + v
+	name, tags := m.name, m.tags
+	if shape := getMetricCatalogue().shape(m.name); shape != nil {
+		name, tags = shape.apply(name, tags)
+	}
+	tags = getCardinalityLimiter().limit(tags)
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	aspect := name
+	for _, k := range keys {
+		aspect += "," + k + "=" + tags[k]
 	}
 	return aspect
 }
 
+// enabled return false if the metric catalogue disables the Measure
+func (m *Measure) enabled() bool {
+	shape := getMetricCatalogue().shape(m.name)
+	return shape == nil || shape.enabled
+}
+
 // IncrementCounter a Counter
 func (m *Measure) IncrementCounter() {
-	err := (*m.client).Incr(m.GetAspect(), 1)
-	if err != nil {
-		logging.Error.Println(err)
-	}
+	m.Add(1)
 }
 
-// IncrementGauge a Gauge
-func (m *Measure) IncrementGauge() {
-	aspect := m.GetAspect()
-	if shouldResetGauge(aspect) {
-		err := (*m.client).Gauge(aspect, 0)
+// Add increment a Counter by value, and its rollups
+func (m *Measure) Add(value int64) {
+	if !m.enabled() {
+		return
+	}
+	for _, aspect := range m.aspectsWithRollups() {
+		err := (*m.client).Incr(aspect, value)
 		if err != nil {
-			logging.Error.Println(err)
+			statsdError(err)
 		}
 	}
+}
 
-	err := (*m.client).GaugeDelta(aspect, 1)
+// Gauge set a Gauge to an absolute value, an absolute value can not be rolled up
+func (m *Measure) Gauge(value int64) {
+	if !m.enabled() {
+		return
+	}
+	aspect := m.GetAspect()
+	shouldResetGauge(aspect)
+
+	err := (*m.client).Gauge(aspect, value)
 	if err != nil {
-		logging.Error.Println(err)
+		statsdError(err)
 	}
 }
 
-// DecrementGauge a Gauge
+// IncrementGauge a Gauge, and its rollups
+func (m *Measure) IncrementGauge() {
+	m.gaugeDelta(1)
+}
+
+// DecrementGauge a Gauge, and its rollups
 func (m *Measure) DecrementGauge() {
-	aspect := m.GetAspect()
-	if shouldResetGauge(aspect) {
-		err := (*m.client).Gauge(aspect, 0)
-		if err != nil {
-			logging.Error.Println(err)
-		}
+	m.gaugeDelta(-1)
+}
+
+func (m *Measure) gaugeDelta(delta int64) {
+	if !m.enabled() {
+		return
 	}
+	for _, aspect := range m.aspectsWithRollups() {
+		if shouldResetGauge(aspect) {
+			err := (*m.client).Gauge(aspect, 0)
+			if err != nil {
+				statsdError(err)
+			}
+		}
 
-	err := (*m.client).GaugeDelta(aspect, -1)
-	if err != nil {
-		logging.Error.Println(err)
+		err := (*m.client).GaugeDelta(aspect, delta)
+		if err != nil {
+			statsdError(err)
+		}
 	}
 }
 
-// Timing warp Statsd.Timing
+// Timing warp Statsd.Timing, and its rollups
 func (m *Measure) Timing(delta int64) {
-	err := (*m.client).Timing(m.GetAspect(), delta)
-	if err != nil {
-		logging.Error.Println(err)
+	if !m.enabled() {
+		return
+	}
+	for _, aspect := range m.aspectsWithRollups() {
+		err := (*m.client).Timing(aspect, delta)
+		if err != nil {
+			statsdError(err)
+		}
 	}
 }
@@ -0,0 +1,38 @@
+package statsdami
+
+import (
+	"time"
+)
+
+// Poller run a function periodically until stopped
+type Poller struct {
+	exitChan chan bool
+}
+
+// NewPoller run poll immediately then every interval in a goroutine
+func NewPoller(interval time.Duration, poll func()) *Poller {
+	p := &Poller{
+		exitChan: make(chan bool, 1),
+	}
+
+	go func(p *Poller, interval time.Duration) {
+		for {
+			poll()
+
+			select {
+			case <-p.exitChan:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}(p, interval)
+	return p
+}
+
+// Stop the Poller goroutine
+func (p *Poller) Stop() {
+	select {
+	case p.exitChan <- true:
+	default:
+	}
+}
@@ -0,0 +1,72 @@
+package statsdami
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quipo/statsd"
+)
+
+// PrintClient a statsd client writing the metrics to w in the statsd line format instead of sending them,
+// one "aspect:value|type" per line
+type PrintClient struct {
+	statsd.NoopClient
+
+	mutex  *sync.Mutex
+	writer io.Writer
+	prefix string
+}
+
+// NewPrintClient create a PrintClient, prefix is prepended to the aspects like statsd.NewStatsdClient does
+func NewPrintClient(w io.Writer, prefix string) *PrintClient {
+	return &PrintClient{mutex: new(sync.Mutex), writer: w, prefix: prefix}
+}
+
+func (c *PrintClient) print(stat string, value interface{}, kind string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := fmt.Fprintf(c.writer, "%s%s:%v|%s\n", c.prefix, stat, value, kind)
+	return err
+}
+
+// Incr print a counter increment
+func (c *PrintClient) Incr(stat string, count int64) error {
+	return c.print(stat, count, "c")
+}
+
+// Decr print a counter decrement
+func (c *PrintClient) Decr(stat string, count int64) error {
+	return c.print(stat, -count, "c")
+}
+
+// Timing print a timing in milliseconds
+func (c *PrintClient) Timing(stat string, delta int64) error {
+	return c.print(stat, delta, "ms")
+}
+
+// PrecisionTiming print a timing in milliseconds
+func (c *PrintClient) PrecisionTiming(stat string, delta time.Duration) error {
+	return c.print(stat, fmt.Sprintf("%.6f", float64(delta)/float64(time.Millisecond)), "ms")
+}
+
+// Gauge print a gauge value
+func (c *PrintClient) Gauge(stat string, value int64) error {
+	return c.print(stat, value, "g")
+}
+
+// GaugeDelta print a gauge change, always signed
+func (c *PrintClient) GaugeDelta(stat string, value int64) error {
+	return c.print(stat, fmt.Sprintf("%+d", value), "g")
+}
+
+// FGauge print a float gauge value
+func (c *PrintClient) FGauge(stat string, value float64) error {
+	return c.print(stat, value, "g")
+}
+
+// FGaugeDelta print a float gauge change, always signed
+func (c *PrintClient) FGaugeDelta(stat string, value float64) error {
+	return c.print(stat, fmt.Sprintf("%+g", value), "g")
+}
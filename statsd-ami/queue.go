@@ -0,0 +1,307 @@
+package statsdami
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+
+	"github.com/quipo/statsd"
+)
+
+type statsdQueueHandler func(*statsd.Statsd, *asterisk.Queue, *ami.Event, map[string]string)
+
+var queuesMutex = new(sync.RWMutex)
+var queues = make(map[string]*asterisk.Queue)
+
+// DefaultServiceLevel time within which a queue caller should be answered
+var DefaultServiceLevel = 20 * time.Second
+
+var serviceLevels = make(map[string]time.Duration)
+
+// pausedReasons pause reasons published per queue, to reset them once no member uses them
+var pausedReasons = make(map[string]map[string]bool)
+
+// SetQueueServiceLevels set the default service level and the per queue ones
+func SetQueueServiceLevels(defaultServiceLevel time.Duration, perQueue map[string]time.Duration) {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	DefaultServiceLevel = defaultServiceLevel
+	serviceLevels = make(map[string]time.Duration)
+	for name, serviceLevel := range perQueue {
+		serviceLevels[name] = serviceLevel
+	}
+	for name, queue := range queues {
+		queue.ServiceLevel = getServiceLevel(name)
+	}
+}
+
+// GetQueuesCount return number of queues seen
+func GetQueuesCount() int {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	return len(queues)
+}
+
+// DumpQueues dump queues
+func DumpQueues(logger *log.Logger) {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	logger.Println(len(queues), " queues")
+	for k, v := range queues {
+		logger.Printf("%s => %v\n", k, v)
+	}
+}
+
+func getServiceLevel(name string) time.Duration {
+	if serviceLevel, found := serviceLevels[name]; found {
+		return serviceLevel
+	}
+	return DefaultServiceLevel
+}
+
+// getQueue return the queue named name, queuesMutex must be locked
+func getQueue(name string) *asterisk.Queue {
+	queue, found := queues[name]
+	if !found {
+		queue = asterisk.NewQueue(name, getServiceLevel(name))
+		queues[name] = queue
+	}
+	return queue
+}
+
+// secondsParam return a duration from an event param expressed in seconds
+func secondsParam(get func(string, string) string, key string) time.Duration {
+	value, err := strconv.ParseInt(get(key, "0"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(value) * time.Second
+}
+
+func milliseconds(d time.Duration) int64 {
+	return d.Nanoseconds() / int64(1000000)
+}
+
+// NewQueueHandler call handler with the Queue of the event
+//
+//	handler(*statsd.StatsdClient, *asterisk.Queue, *ami.Event, map[string]string)
+func NewQueueHandler(client *statsd.Statsd, handler statsdQueueHandler) func(*ami.Event) {
+	return func(message *ami.Event) {
+		get := mapGetter(message.Params)
+
+		name := get("Queue", "")
+		if name == "" {
+			logging.Error.Println("no queue found in", message)
+			return
+		}
+
+		queuesMutex.Lock()
+		defer queuesMutex.Unlock()
+		queue := getQueue(name)
+		handler(client, queue, message, map[string]string{"queue": queue.Name})
+	}
+}
+
+// SeedQueues load queues members and waiting callers with a QueueStatus action and publish their gauges
+func SeedQueues(client *statsd.Statsd, amiClient *ami.Client, timeout time.Duration) error {
+	events, err := amiClient.ActionList("QueueStatus", nil, timeout)
+	if err != nil {
+		return err
+	}
+
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+
+	seeded := make(map[string]*asterisk.Queue)
+	for _, event := range events {
+		get := mapGetter(event.Params)
+		name := get("Queue", "")
+		if name == "" {
+			continue
+		}
+
+		queue := getQueue(name)
+		if _, found := seeded[name]; !found {
+			queue.Members = make(map[string]*asterisk.QueueMember)
+			queue.Callers = make(map[string]*asterisk.QueueCaller)
+			seeded[name] = queue
+		}
+
+		switch event.ID {
+		case "QueueMember":
+			queue.SetMember(memberFromEvent(get))
+		case "QueueEntry":
+			queue.Join(get("Uniqueid", ""))
+		}
+	}
+
+	if client == nil {
+		return nil
+	}
+	for name, queue := range seeded {
+		tags := map[string]string{"queue": name}
+		NewMeasure(client, "queue_waiting", tags).Gauge(queue.Waiting())
+		publishQueueMembers(client, queue, tags)
+	}
+	return nil
+}
+
+func memberFromEvent(get func(string, string) string) *asterisk.QueueMember {
+	// QueueStatus list members with Location/Name, member events use Interface/MemberName
+	iface := get("Interface", get("Location", ""))
+	return &asterisk.QueueMember{
+		Interface:    iface,
+		Name:         get("MemberName", get("Name", iface)),
+		Status:       get("Status", ""),
+		InCall:       get("InCall", "0") == "1",
+		Paused:       get("Paused", "0") == "1",
+		PausedReason: get("PausedReason", ""),
+	}
+}
+
+// publishQueueMembers publish the queue_members gauges of a queue, queuesMutex must be locked
+func publishQueueMembers(client *statsd.Statsd, queue *asterisk.Queue, tags map[string]string) {
+	published, found := pausedReasons[queue.Name]
+	if !found {
+		published = make(map[string]bool)
+		pausedReasons[queue.Name] = published
+	}
+
+	states := queue.MemberStates()
+	for reason := range published {
+		if _, found := states[asterisk.QueueMemberPaused][reason]; !found {
+			states[asterisk.QueueMemberPaused][reason] = 0
+			delete(published, reason)
+		}
+	}
+
+	for state, reasons := range states {
+		for reason, count := range reasons {
+			if state == asterisk.QueueMemberPaused && count > 0 {
+				published[reason] = true
+			}
+			if reason == "" {
+				reason = "-"
+			}
+			NewMeasure(client, "queue_members", tags).
+				Tag("state", state).
+				Tag("reason", reason).
+				Gauge(count)
+		}
+	}
+}
+
+// EventQueueCallerJoinHandler handle a caller entering a queue
+func EventQueueCallerJoinHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	queue.Join(get("Uniqueid", ""))
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_calls", tags).IncrementCounter()
+	NewMeasure(client, "queue_waiting", tags).Gauge(queue.Waiting())
+}
+
+// EventQueueCallerLeaveHandler handle a caller leaving a queue (answered or not)
+func EventQueueCallerLeaveHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	queue.Leave(get("Uniqueid", ""))
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_waiting", tags).Gauge(queue.Waiting())
+}
+
+// EventQueueCallerAbandonHandler handle a caller hanging up while waiting in a queue
+func EventQueueCallerAbandonHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	queue.Abandon()
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_abandoned", tags).IncrementCounter()
+	NewMeasure(client, "queue_abandon_time", tags).Timing(milliseconds(secondsParam(get, "HoldTime")))
+	NewMeasure(client, "queue_service_level", tags).Gauge(queue.ServiceLevelPercent())
+}
+
+// EventAgentCalledHandler handle an agent ringing for a queue caller
+func EventAgentCalledHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_agent_calls", tags).IncrementCounter()
+}
+
+// EventAgentConnectHandler handle an agent answering a queue caller
+func EventAgentConnectHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	holdTime := secondsParam(get, "HoldTime")
+	queue.Answer(holdTime)
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_answered", tags).IncrementCounter()
+	NewMeasure(client, "queue_wait_time", tags).Timing(milliseconds(holdTime))
+	NewMeasure(client, "queue_service_level", tags).Gauge(queue.ServiceLevelPercent())
+}
+
+// EventAgentCompleteHandler handle the end of a queue call answered by an agent
+func EventAgentCompleteHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "queue_talk_time", tags).
+		Tag("reason", get("Reason", "-")).
+		Timing(milliseconds(secondsParam(get, "TalkTime")))
+}
+
+// EventQueueMemberHandler handle a queue member added, paused or changing state
+//
+//	QueueMemberAdded, QueueMemberPause and QueueMemberStatus carry the whole member state
+func EventQueueMemberHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	queue.SetMember(memberFromEvent(get))
+
+	if client == nil {
+		return
+	}
+	publishQueueMembers(client, queue, tags)
+}
+
+// EventQueueMemberRemovedHandler handle a queue member removed
+func EventQueueMemberRemovedHandler(client *statsd.Statsd,
+	queue *asterisk.Queue, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	queue.RemoveMember(memberFromEvent(get).Interface)
+
+	if client == nil {
+		return
+	}
+	publishQueueMembers(client, queue, tags)
+}
@@ -0,0 +1,137 @@
+package statsdami
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RollupValue value of the rolled up tags
+const RollupValue = "All"
+
+// RollupConfig aggregations of a metric
+//
+//	Dimensions: sets of tags, the metric is emitted again for each set with its tags set to RollupValue
+//	Tags: optional call tags the metric is also tagged with, see OptionalTags
+//	Backend: emit the metric once, the rollups are left to the backend
+type RollupConfig struct {
+	Dimensions [][]string `json:"dimensions"`
+	Tags       []string   `json:"tags"`
+	Backend    bool       `json:"backend"`
+}
+
+// OptionalTags call tags emitted only with the metrics whose RollupConfig lists them
+var OptionalTags = map[string]bool{
+	"context": true,
+	"account": true,
+}
+
+// DefaultRollups the call metrics and the KPIs are emitted a second time with trunk=All
+var DefaultRollups = map[string]RollupConfig{
+	"calls":               trunkRollup,
+	"concurrent":          trunkRollup,
+	"on_hold":             trunkRollup,
+	"active_duration":     trunkRollup,
+	"total_duration":      trunkRollup,
+	"hold_count":          trunkRollup,
+	"hold_duration":       trunkRollup,
+	"rtcp_jitter":         trunkRollup,
+	"rtcp_jitter_max":     trunkRollup,
+	"rtcp_loss":           trunkRollup,
+	"rtcp_rtt":            trunkRollup,
+	"mos":                 trunkRollup,
+	"bridged_duration":    trunkRollup,
+	"transfers":           trunkRollup,
+	"abandoned_transfers": trunkRollup,
+	"long_call":           trunkRollup,
+	"evicted_calls":       trunkRollup,
+	"kpi_calls":           trunkRollup,
+	"kpi_asr":             trunkRollup,
+	"kpi_acd":             trunkRollup,
+	"kpi_ner":             trunkRollup,
+}
+
+var trunkRollup = RollupConfig{Dimensions: [][]string{{"trunk"}}}
+
+var rollupsMutex = new(sync.RWMutex)
+var rollups = DefaultRollups
+
+// SetRollups set the rollups by metric default name, "*" for the metrics not listed
+//
+// they replace DefaultRollups, nil to emit every metric once
+func SetRollups(configs map[string]RollupConfig) error {
+	for metric, config := range configs {
+		for _, dimension := range config.Dimensions {
+			if len(dimension) == 0 {
+				return fmt.Errorf("metric %s: empty rollup dimension", metric)
+			}
+		}
+		for _, tag := range config.Tags {
+			if !OptionalTags[tag] {
+				return fmt.Errorf("metric %s: %s is not an optional tag", metric, tag)
+			}
+		}
+	}
+
+	rollupsMutex.Lock()
+	defer rollupsMutex.Unlock()
+	rollups = configs
+	return nil
+}
+
+func getRollup(name string) RollupConfig {
+	rollupsMutex.RLock()
+	defer rollupsMutex.RUnlock()
+	if config, found := rollups[name]; found {
+		return config
+	}
+	return rollups["*"]
+}
+
+// optionalTag return true if the rollup config of a metric enables an optional tag
+func optionalTag(name string, tag string) bool {
+	for _, enabled := range getRollup(name).Tags {
+		if enabled == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// aspectsWithRollups return the aspect of the Measure followed by the aspects of its rollups computed by the daemon,
+// a dimension is skipped if the Measure does not have all its tags, or if the catalogue shapes it into an aspect
+// already emitted: a rollup of a dropped tag would count the observation twice
+func (m *Measure) aspectsWithRollups() []string {
+	aspects := []string{m.GetAspect()}
+	seen := map[string]bool{aspects[0]: true}
+	for _, tags := range rollupTags(getRollup(m.name), m.tags) {
+		rollup := &Measure{name: m.name, tags: tags, client: m.client}
+		if aspect := rollup.GetAspect(); !seen[aspect] {
+			seen[aspect] = true
+			aspects = append(aspects, aspect)
+		}
+	}
+	return aspects
+}
+
+// rollupTags return the tags of the rollups computed by the daemon for a config,
+// a dimension is skipped if tags does not have all its tags
+func rollupTags(config RollupConfig, tags map[string]string) []map[string]string {
+	if config.Backend {
+		return nil
+	}
+	rollups := make([]map[string]string, 0, len(config.Dimensions))
+	for _, dimension := range config.Dimensions {
+		rollup := copyTags(tags)
+		for _, tag := range dimension {
+			if _, found := rollup[tag]; !found {
+				rollup = nil
+				break
+			}
+			rollup[tag] = RollupValue
+		}
+		if rollup != nil {
+			rollups = append(rollups, rollup)
+		}
+	}
+	return rollups
+}
@@ -0,0 +1,100 @@
+package statsdami
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollups(t *testing.T) {
+	assert := assert.New(t)
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	tags := map[string]string{"trunk": "gw1", "direction": "inbound"}
+	emit := func() []string {
+		output.Reset()
+		NewMeasure(&client, "calls", tags).IncrementCounter()
+		NewMeasure(&client, "total_duration", tags).Timing(2000)
+		NewMeasure(&client, "bridged_duration", map[string]string{"direction": "inbound"}).Timing(1000)
+		NewMeasure(&client, "concurrent", tags).Gauge(3)
+		return strings.Split(strings.TrimSpace(output.String()), "\n")
+	}
+
+	assert.Equal([]string{
+		"calls,direction=inbound,trunk=gw1:1|c",
+		"calls,direction=inbound,trunk=All:1|c",
+		"total_duration,direction=inbound,trunk=gw1:2000|ms",
+		"total_duration,direction=inbound,trunk=All:2000|ms",
+		"bridged_duration,direction=inbound:1000|ms",
+		"concurrent,direction=inbound,trunk=gw1:3|g",
+	}, emit(), "default rollups not emitted")
+
+	assert.Nil(SetRollups(map[string]RollupConfig{
+		"*":     {Backend: true},
+		"calls": {Dimensions: [][]string{{"direction"}, {"trunk", "direction"}}},
+	}))
+	assert.Equal([]string{
+		"calls,direction=inbound,trunk=gw1:1|c",
+		"calls,direction=All,trunk=gw1:1|c",
+		"calls,direction=All,trunk=All:1|c",
+		"total_duration,direction=inbound,trunk=gw1:2000|ms",
+		"bridged_duration,direction=inbound:1000|ms",
+		"concurrent,direction=inbound,trunk=gw1:3|g",
+	}, emit(), "configured rollups not emitted")
+
+	assert.NotNil(SetRollups(map[string]RollupConfig{"calls": {Dimensions: [][]string{{}}}}), "empty dimension should fail")
+}
+
+func TestRollupsWithCatalogue(t *testing.T) {
+	assert := assert.New(t)
+
+	catalogue, err := NewMetricCatalogue(map[string]MetricConfig{
+		"*":          {Mappings: map[string]map[string]string{"trunk": {"*": "carrier"}}},
+		"concurrent": {Tags: []string{"direction"}},
+	})
+	assert.Nil(err)
+	SetMetricCatalogue(catalogue)
+	defer SetMetricCatalogue(nil)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	tags := map[string]string{"trunk": "gw1", "direction": "inbound"}
+	NewMeasure(&client, "calls", tags).IncrementCounter()
+	NewMeasure(&client, "concurrent", tags).IncrementGauge()
+
+	metrics := output.String()
+	assert.Equal(1, strings.Count(metrics, "calls,direction=inbound,trunk=carrier:1|c\n"), "calls not emitted once")
+	assert.Equal(1, strings.Count(metrics, "calls,direction=inbound,trunk=All:1|c\n"), "calls rollup not emitted once")
+	assert.Equal(1, strings.Count(metrics, "concurrent,direction=inbound:+1|g\n"), "rollup of a dropped tag should not be counted twice")
+	assert.NotContains(metrics, "trunk=All:+1|g", "rollup of a dropped tag should not be emitted")
+}
+
+func TestOptionalTags(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(SetRollups(map[string]RollupConfig{
+		"calls":      {Tags: []string{"context"}, Dimensions: [][]string{{"trunk"}}},
+		"concurrent": {Tags: []string{"context", "account"}, Backend: true},
+	}))
+	defer SetRollups(DefaultRollups)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	tags := map[string]string{"trunk": "gw1", "direction": "inbound", "context": "from-trunk", "account": "4242"}
+	NewMeasure(&client, "calls", tags).IncrementCounter()
+	NewMeasure(&client, "total_duration", tags).Timing(2000)
+	NewMeasure(&client, "concurrent", tags).Gauge(1)
+
+	assert.Equal([]string{
+		"calls,context=from-trunk,direction=inbound,trunk=gw1:1|c",
+		"calls,context=from-trunk,direction=inbound,trunk=All:1|c",
+		"total_duration,direction=inbound,trunk=gw1:2000|ms",
+		"concurrent,account=4242,context=from-trunk,direction=inbound,trunk=gw1:1|g",
+	}, strings.Split(strings.TrimSpace(output.String()), "\n"), "optional tags not emitted only when enabled")
+
+	assert.NotNil(SetRollups(map[string]RollupConfig{"calls": {Tags: []string{"trunk"}}}), "a tag not optional should fail")
+}
@@ -0,0 +1,160 @@
+package statsdami
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+	"github.com/quipo/statsd"
+)
+
+// DefaultSelfPrefix prefix of the metrics about the daemon itself
+const DefaultSelfPrefix = "monitor_"
+
+// handlerLatency handling time of an event type since the last publication
+type handlerLatency struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+var selfMutex = new(sync.Mutex)
+var selfPrefix = DefaultSelfPrefix
+var latencies = make(map[string]*handlerLatency)
+var connections int64
+var statsdErrors int64
+
+// publishMutex protects the counters published by the last PublishSelf, counters are published as deltas
+var publishMutex = new(sync.Mutex)
+var publishedEvents = make(map[*ami.Client]map[string]ami.EventStats)
+var publishedReconnects int64
+var publishedStatsdErrors int64
+var publishedLimited = make(map[string]uint64)
+
+// SetSelfPrefix set the prefix of the metrics about the daemon itself
+func SetSelfPrefix(prefix string) {
+	selfMutex.Lock()
+	defer selfMutex.Unlock()
+	selfPrefix = prefix
+}
+
+// HandlerLatency ami.Middleware measuring the time spent handling each event type
+func HandlerLatency(next func(*ami.Event)) func(*ami.Event) {
+	return func(ev *ami.Event) {
+		start := time.Now()
+		next(ev)
+		elapsed := time.Since(start)
+
+		selfMutex.Lock()
+		defer selfMutex.Unlock()
+		latency, found := latencies[ev.ID]
+		if !found {
+			latency = &handlerLatency{}
+			latencies[ev.ID] = latency
+		}
+		latency.count++
+		latency.total += elapsed
+		if elapsed > latency.max {
+			latency.max = elapsed
+		}
+	}
+}
+
+// CountConnection count a successful AMI connection, the ones after the first are reconnections
+func CountConnection() {
+	selfMutex.Lock()
+	defer selfMutex.Unlock()
+	connections++
+}
+
+// statsdError log and count an error sending a metric
+func statsdError(err error) {
+	logging.Error.Println(err)
+	selfMutex.Lock()
+	defer selfMutex.Unlock()
+	statsdErrors++
+}
+
+// PublishSelf publish the metrics about the daemon and its AMI client
+//
+// events counters, reconnections and statsd errors are published as the increase since the last publication,
+// handler latencies as the average and maximum in microseconds since the last publication
+func PublishSelf(client *statsd.Statsd, amiClient *ami.Client) {
+	if client == nil {
+		return
+	}
+
+	publishMutex.Lock()
+	defer publishMutex.Unlock()
+
+	selfMutex.Lock()
+	prefix := selfPrefix
+	current := latencies
+	latencies = make(map[string]*handlerLatency)
+	reconnects := connections - 1
+	if reconnects < 0 {
+		reconnects = 0
+	}
+	reconnectsDelta := reconnects - publishedReconnects
+	publishedReconnects = reconnects
+	errorsDelta := statsdErrors - publishedStatsdErrors
+	publishedStatsdErrors = statsdErrors
+	selfMutex.Unlock()
+
+	published, found := publishedEvents[amiClient]
+	if !found {
+		published = make(map[string]ami.EventStats)
+		publishedEvents[amiClient] = published
+	}
+	for event, stats := range amiClient.GetEventStats() {
+		previous := published[event]
+		tags := map[string]string{"event": event}
+		if n := int64(stats.Received - previous.Received); n > 0 {
+			NewMeasure(client, prefix+"events_received", tags).Add(n)
+		}
+		if n := int64(stats.Used - previous.Used); n > 0 {
+			NewMeasure(client, prefix+"events_used", tags).Add(n)
+		}
+		if n := int64(stats.Dropped - previous.Dropped); n > 0 {
+			NewMeasure(client, prefix+"events_dropped", tags).Add(n)
+		}
+		published[event] = stats
+	}
+
+	for event, latency := range current {
+		tags := map[string]string{"event": event}
+		NewMeasure(client, prefix+"handler_latency_avg_us", tags).Gauge(int64(latency.total/time.Microsecond) / latency.count)
+		NewMeasure(client, prefix+"handler_latency_max_us", tags).Gauge(int64(latency.max / time.Microsecond))
+	}
+
+	for tag, stats := range GetCardinalityStats() {
+		tags := map[string]string{"tag": tag}
+		if n := int64(stats.Limited - publishedLimited[tag]); n > 0 {
+			NewMeasure(client, prefix+"cardinality_limited", tags).Add(n)
+		}
+		publishedLimited[tag] = stats.Limited
+		NewMeasure(client, prefix+"cardinality_values", tags).Gauge(int64(stats.Values))
+	}
+
+	if reconnectsDelta > 0 {
+		NewMeasure(client, prefix+"reconnects", nil).Add(reconnectsDelta)
+	}
+	if errorsDelta > 0 {
+		NewMeasure(client, prefix+"statsd_errors", nil).Add(errorsDelta)
+	}
+
+	NewMeasure(client, prefix+"pending_actions", nil).Gauge(int64(amiClient.GetPendingActionsCount()))
+	NewMeasure(client, prefix+"queued_events", nil).Gauge(int64(amiClient.GetQueuedEventsCount()))
+	NewMeasure(client, prefix+"tracked_calls", nil).Gauge(int64(GetPendingCallsCount()))
+	NewMeasure(client, prefix+"gauges", nil).Gauge(int64(GetGaugeCount()))
+	NewMeasure(client, prefix+"ping_rtt", nil).Gauge(milliseconds(amiClient.GetLastPingRTT()))
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	NewMeasure(client, prefix+"goroutines", nil).Gauge(int64(runtime.NumGoroutine()))
+	NewMeasure(client, prefix+"heap_alloc_bytes", nil).Gauge(int64(memory.HeapAlloc))
+	NewMeasure(client, prefix+"sys_bytes", nil).Gauge(int64(memory.Sys))
+	NewMeasure(client, prefix+"gc_count", nil).Gauge(int64(memory.NumGC))
+}
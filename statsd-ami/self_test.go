@@ -0,0 +1,71 @@
+package statsdami
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("network unreachable")
+}
+
+func TestPublishSelf(t *testing.T) {
+	assert := assert.New(t)
+	SetSelfPrefix("self.")
+	defer SetSelfPrefix(DefaultSelfPrefix)
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	// counters are published as deltas: publish what the other tests counted, after a first connection
+	CountConnection()
+	PublishSelf(&client, ami.New("", "", ""))
+	output.Reset()
+
+	amiClient := ami.New("", "", "", ami.StrictHandlers)
+	amiClient.Use(HandlerLatency)
+	amiClient.RegisterHandler("VarSet", func(ev *ami.Event) { time.Sleep(2 * time.Millisecond) })
+	session := "# 2026-01-01T00:00:00Z\r\nEvent: VarSet\r\n\r\n" +
+		"# 2026-01-01T00:00:00Z\r\nEvent: VarSet\r\n\r\n" +
+		"# 2026-01-01T00:00:00Z\r\nEvent: Newexten\r\n\r\n"
+	assert.Nil(amiClient.Replay(strings.NewReader(session), 0))
+	CountConnection()
+
+	failing := statsd.Statsd(NewPrintClient(failingWriter{}, ""))
+	NewMeasure(&failing, "lost", nil).IncrementCounter()
+
+	PublishSelf(&client, amiClient)
+	metrics := output.String()
+	assert.Contains(metrics, "self.events_received,event=VarSet:2|c\n", "received events not published")
+	assert.Contains(metrics, "self.events_used,event=VarSet:2|c\n", "used events not published")
+	assert.Contains(metrics, "self.events_received,event=Newexten:1|c\n", "received events not published")
+	assert.NotContains(metrics, "self.events_used,event=Newexten", "unused events published as used")
+	assert.Contains(metrics, "self.reconnects:1|c\n", "reconnects not published")
+	assert.Contains(metrics, "self.statsd_errors:1|c\n", "statsd errors not published")
+	assert.Contains(metrics, "self.tracked_calls:0|g\n", "tracked calls not published")
+	assert.Regexp(`self\.goroutines:[1-9][0-9]*\|g`, metrics, "goroutines not published")
+
+	latency := regexp.MustCompile(`self\.handler_latency_avg_us,event=VarSet:([0-9]+)\|g`).FindStringSubmatch(metrics)
+	if assert.NotNil(latency, "handler latency not published") {
+		us, _ := strconv.Atoi(latency[1])
+		assert.True(us >= 2000, "handler latency not correctly measured")
+	}
+
+	output.Reset()
+	PublishSelf(&client, amiClient)
+	metrics = output.String()
+	assert.NotContains(metrics, "self.events_received", "counters should be published as deltas")
+	assert.NotContains(metrics, "self.reconnects", "counters should be published as deltas")
+	assert.NotContains(metrics, "self.handler_latency", "latencies should be reset once published")
+	assert.Contains(metrics, "self.pending_actions:0|g\n", "gauges should always be published")
+}
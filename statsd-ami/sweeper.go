@@ -0,0 +1,113 @@
+package statsdami
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+
+	"github.com/quipo/statsd"
+)
+
+// DefaultMaxCallDuration duration after which a call is considered as long running
+var DefaultMaxCallDuration = 4 * time.Hour
+
+var sweeperMutex = new(sync.RWMutex)
+var maxCallDurations = make(map[string]time.Duration)
+
+// SetMaxCallDurations set the default maximum call duration and the per trunk ones
+func SetMaxCallDurations(defaultMaxDuration time.Duration, perTrunk map[string]time.Duration) {
+	sweeperMutex.Lock()
+	defer sweeperMutex.Unlock()
+	DefaultMaxCallDuration = defaultMaxDuration
+	maxCallDurations = make(map[string]time.Duration)
+	for trunk, maxDuration := range perTrunk {
+		maxCallDurations[trunk] = maxDuration
+	}
+}
+
+func getMaxCallDuration(trunk string) time.Duration {
+	sweeperMutex.RLock()
+	defer sweeperMutex.RUnlock()
+	if maxDuration, found := maxCallDurations[trunk]; found {
+		return maxDuration
+	}
+	return DefaultMaxCallDuration
+}
+
+// SweepCalls flag the calls running for longer than their maximum duration,
+// check their channel still exists with a Status action and evict them if not
+func SweepCalls(client *statsd.Statsd, amiClient *ami.Client, timeout time.Duration) {
+	suspects := make([]*asterisk.Call, 0)
+
+	forEachCall(func(call *asterisk.Call) {
+		if call.Age() > getMaxCallDuration(call.GetTrunkName()) {
+			suspects = append(suspects, call)
+		}
+	})
+
+	for _, call := range suspects {
+		flagLongCall(client, call)
+
+		_, err := amiClient.ActionList("Status", ami.Params{"Channel": call.Channel}, timeout)
+		if err != ami.ErrNoSuchChannel {
+			// still alive or could not verify
+			continue
+		}
+		evict(client, call)
+	}
+}
+
+// flagLongCall count a long running call the first time it is seen
+func flagLongCall(client *statsd.Statsd, call *asterisk.Call) {
+	shard := shardOf(call.UniqueID)
+	shard.dispatch.Lock()
+	defer shard.dispatch.Unlock()
+
+	if call.LongCall {
+		return
+	}
+	if _, found := isWatched(call.UniqueID); !found {
+		// hangup received meanwhile
+		return
+	}
+	call.LongCall = true
+	logging.Warning.Println("long call", call.UniqueID, call.Channel, "running for", call.Age())
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "long_call", callTags(call)).IncrementCounter()
+}
+
+// evict stop watching a call whose Hangup was lost and correct its gauges
+func evict(client *statsd.Statsd, call *asterisk.Call) {
+	shard := shardOf(call.UniqueID)
+	shard.dispatch.Lock()
+	defer shard.dispatch.Unlock()
+
+	if _, found := isWatched(call.UniqueID); !found {
+		// hangup received meanwhile
+		return
+	}
+	logging.Warning.Println("evicting call", call.UniqueID, call.Channel, "channel does not exist anymore")
+
+	tags := callTags(call)
+	for _, bridge := range call.OpenBridges() {
+		leaveBridge(nil, call, bridge.ID, tags)
+	}
+	onHold := call.Unhold()
+	unwatch(call)
+
+	if client == nil {
+		return
+	}
+
+	NewMeasure(client, "concurrent", tags).DecrementGauge()
+	if onHold {
+		NewMeasure(client, "on_hold", tags).DecrementGauge()
+	}
+	NewMeasure(client, "evicted_calls", tags).IncrementCounter()
+}
@@ -0,0 +1,81 @@
+package statsdami
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.HandleAction("Status", func(action amitest.Frame) []amitest.Frame {
+		if action.Get("Channel") != "SIP/provider-00000002" {
+			return []amitest.Frame{amitest.Error("No such channel")}
+		}
+		return amitest.EventList("StatusComplete", amitest.Frame{"Event": "Status", "Channel": action.Get("Channel")})
+	})
+
+	amiClient := ami.New(server.Addr(), "admin", "secret")
+	if err := amiClient.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go amiClient.Run()
+	defer amiClient.Close()
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	for _, id := range []string{"1", "2"} {
+		frame := "Event: Newchannel\r\n" +
+			"Channel: SIP/provider-0000000" + id + "\r\n" +
+			"CallerIDNum: 0611223344\r\n" +
+			"Exten: 100\r\n" +
+			"Context: from-trunk\r\n" +
+			"Uniqueid: sweep." + id + "\r\n\r\n"
+		NewHandler(&client, EventNewChannelHandler)(readEvent(t, frame))
+	}
+	NewHandler(&client, EventHoldHandler)(readEvent(t, "Event: Hold\r\nChannel: SIP/provider-00000001\r\nUniqueid: sweep.1\r\n\r\n"))
+	defer func() {
+		for _, id := range []string{"sweep.1", "sweep.2"} {
+			if call, found := isWatched(id); found {
+				unwatch(call)
+			}
+		}
+	}()
+
+	SetMaxCallDurations(time.Nanosecond, nil)
+	defer SetMaxCallDurations(4*time.Hour, nil)
+
+	output.Reset()
+	SweepCalls(&client, amiClient, time.Second)
+	SweepCalls(&client, amiClient, time.Second)
+
+	_, watched := isWatched("sweep.1")
+	assert.False(watched, "call whose channel is gone should be evicted")
+	_, watched = isWatched("sweep.2")
+	assert.True(watched, "call whose channel exists should be kept")
+
+	assert.ElementsMatch([]string{
+		"long_call,direction=inbound,trunk=provider:1|c",
+		"long_call,direction=inbound,trunk=All:1|c",
+		"concurrent,direction=inbound,trunk=provider:-1|g",
+		"concurrent,direction=inbound,trunk=All:-1|g",
+		"on_hold,direction=inbound,trunk=provider:-1|g",
+		"on_hold,direction=inbound,trunk=All:-1|g",
+		"evicted_calls,direction=inbound,trunk=provider:1|c",
+		"evicted_calls,direction=inbound,trunk=All:1|c",
+		"long_call,direction=inbound,trunk=provider:1|c",
+		"long_call,direction=inbound,trunk=All:1|c",
+	}, strings.Split(strings.TrimSpace(output.String()), "\n"), "gauges not corrected")
+}
@@ -0,0 +1,145 @@
+package statsdami
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/logging"
+
+	"github.com/quipo/statsd"
+)
+
+type statsdSystemHandler func(*statsd.Statsd, *asterisk.System, *ami.Event, map[string]string)
+
+var systemMutex = new(sync.RWMutex)
+var system = &asterisk.System{}
+
+// publishedVersion version of the last system_info published
+var publishedVersion string
+
+// GetSystem return a copy of the Asterisk system status
+func GetSystem() asterisk.System {
+	systemMutex.RLock()
+	defer systemMutex.RUnlock()
+	return *system
+}
+
+// GetAsteriskVersion return the Asterisk version, zero until CoreSettings was polled
+func GetAsteriskVersion() asterisk.Version {
+	systemMutex.RLock()
+	defer systemMutex.RUnlock()
+	return system.Version
+}
+
+// DumpSystem dump the Asterisk system status
+func DumpSystem(logger *log.Logger) {
+	systemMutex.RLock()
+	defer systemMutex.RUnlock()
+	logger.Printf("system => %v\n", system)
+}
+
+// NewSystemHandler call handler with the Asterisk system status
+//
+//	handler(*statsd.StatsdClient, *asterisk.System, *ami.Event, map[string]string)
+func NewSystemHandler(client *statsd.Statsd, handler statsdSystemHandler) func(*ami.Event) {
+	return func(message *ami.Event) {
+		systemMutex.Lock()
+		defer systemMutex.Unlock()
+		handler(client, system, message, map[string]string{})
+	}
+}
+
+// EventFullyBootedHandler handle Asterisk fully booted, sent after each login
+func EventFullyBootedHandler(client *statsd.Statsd,
+	system *asterisk.System, message *ami.Event, tags map[string]string) {
+
+	get := mapGetter(message.Params)
+	system.FullyBooted = true
+
+	// Uptime and LastReload are available since Asterisk 13
+	if uptime, err := strconv.ParseInt(get("Uptime", ""), 10, 64); err == nil {
+		system.StartedAt = time.Now().Add(-time.Duration(uptime) * time.Second)
+	}
+	if lastReload, err := strconv.ParseInt(get("LastReload", ""), 10, 64); err == nil {
+		system.ReloadedAt = time.Now().Add(-time.Duration(lastReload) * time.Second)
+	}
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "system_fully_booted", tags).Gauge(1)
+}
+
+// SystemDisconnected mark Asterisk as not booted until the next FullyBooted event
+func SystemDisconnected(client *statsd.Statsd) {
+	systemMutex.Lock()
+	defer systemMutex.Unlock()
+	system.FullyBooted = false
+
+	if client == nil {
+		return
+	}
+	NewMeasure(client, "system_fully_booted", map[string]string{}).Gauge(0)
+}
+
+// PollSystem run CoreSettings and CoreStatus actions and publish the system gauges,
+// the poll is given up if an action is not answered within timeout
+func PollSystem(client *statsd.Statsd, amiClient *ami.Client, timeout time.Duration) {
+	settings, err := amiClient.ActionTimeout("CoreSettings", nil, timeout)
+	if err != nil || settings.Status != "Success" {
+		logging.Error.Println("CoreSettings failed:", err, settings)
+		return
+	}
+
+	status, err := amiClient.ActionTimeout("CoreStatus", nil, timeout)
+	if err != nil || status.Status != "Success" {
+		logging.Error.Println("CoreStatus failed:", err, status)
+		return
+	}
+
+	systemMutex.Lock()
+	defer systemMutex.Unlock()
+
+	getSettings := mapGetter(settings.Params)
+	version := asterisk.ParseVersion(getSettings("AsteriskVersion", ""))
+	if version.Raw != system.Version.Raw {
+		logging.Info.Println("Asterisk version:", version)
+	}
+	system.Version = version
+	system.MaxCalls, _ = strconv.ParseInt(getSettings("CoreMaxCalls", "0"), 10, 64)
+
+	getStatus := mapGetter(status.Params)
+	system.CurrentCalls, _ = strconv.ParseInt(getStatus("CoreCurrentCalls", "0"), 10, 64)
+	if startedAt, err := parseCoreDate(getStatus("CoreStartupDate", ""), getStatus("CoreStartupTime", "")); err == nil {
+		system.StartedAt = startedAt
+	}
+	if reloadedAt, err := parseCoreDate(getStatus("CoreReloadDate", ""), getStatus("CoreReloadTime", "")); err == nil {
+		system.ReloadedAt = reloadedAt
+	}
+
+	if client == nil {
+		return
+	}
+
+	tags := map[string]string{}
+	// the series of the previous version must not stay at 1
+	if publishedVersion != "" && publishedVersion != version.Raw {
+		NewMeasure(client, "system_info", tags).Tag("version", publishedVersion).Gauge(0)
+	}
+	publishedVersion = version.Raw
+	NewMeasure(client, "system_info", tags).Tag("version", version.Raw).Gauge(1)
+	NewMeasure(client, "system_uptime", tags).Gauge(int64(system.Uptime().Seconds()))
+	NewMeasure(client, "system_since_reload", tags).Gauge(int64(system.SinceReload().Seconds()))
+	NewMeasure(client, "system_current_calls", tags).Gauge(system.CurrentCalls)
+	NewMeasure(client, "system_max_calls", tags).Gauge(system.MaxCalls)
+	NewMeasure(client, "system_fully_booted", tags).Gauge(boolGauge(system.FullyBooted))
+}
+
+// parseCoreDate parse CoreStatus dates expressed in the asterisk server local time
+func parseCoreDate(date string, clock string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", date+" "+clock, time.Local)
+}
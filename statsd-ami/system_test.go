@@ -0,0 +1,73 @@
+package statsdami
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami"
+	"github.com/pgoergler/go-asterisk-statsd/asterisk/ami/amitest"
+	"github.com/quipo/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollSystem(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := amitest.NewServer("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	version := "13.1.0"
+	server.HandleAction("CoreSettings", func(action amitest.Frame) []amitest.Frame {
+		response := amitest.Success("")
+		response["AsteriskVersion"] = version
+		response["CoreMaxCalls"] = "100"
+		return []amitest.Frame{response}
+	})
+	server.HandleAction("CoreStatus", func(action amitest.Frame) []amitest.Frame {
+		response := amitest.Success("")
+		response["CoreCurrentCalls"] = "3"
+		return []amitest.Frame{response}
+	})
+
+	amiClient := ami.New(server.Addr(), "admin", "secret")
+	if err := amiClient.Connect(nil); err != nil {
+		t.Fatal(err)
+	}
+	go amiClient.Run()
+	defer amiClient.Close()
+
+	previous := GetSystem()
+	defer func() {
+		systemMutex.Lock()
+		defer systemMutex.Unlock()
+		*system = previous
+		publishedVersion = ""
+	}()
+
+	var output bytes.Buffer
+	client := statsd.Statsd(NewPrintClient(&output, ""))
+	PollSystem(&client, amiClient, time.Second)
+	metrics := output.String()
+	assert.Contains(metrics, "system_info,version=13.1.0:1|g\n", "version not published")
+	assert.Contains(metrics, "system_current_calls:3|g\n", "current calls not published")
+	assert.NotContains(metrics, "ping_rtt", "ping rtt is published with the metrics about the monitor itself")
+
+	output.Reset()
+	version = "13.2.0"
+	PollSystem(&client, amiClient, time.Second)
+	metrics = output.String()
+	assert.Contains(metrics, "system_info,version=13.1.0:0|g\n", "previous version not reset")
+	assert.Contains(metrics, "system_info,version=13.2.0:1|g\n", "new version not published")
+
+	server.HandleAction("CoreStatus", func(action amitest.Frame) []amitest.Frame {
+		return nil
+	})
+	output.Reset()
+	start := time.Now()
+	PollSystem(&client, amiClient, 50*time.Millisecond)
+	assert.True(time.Since(start) < time.Second, "unanswered poll should be given up")
+	assert.Empty(output.String(), "unanswered poll should not publish")
+}
@@ -0,0 +1,75 @@
+package statsdami
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/pgoergler/go-asterisk-statsd/asterisk"
+)
+
+// callShardsCount number of shards of the calls in progress
+const callShardsCount = 64
+
+// callShard calls in progress whose Uniqueid hash to the same shard
+//
+// mutex protects calls and the Call fields read from other shards (AccountCode),
+// dispatch serializes the handlers of the shard calls with the sweeper evictions
+type callShard struct {
+	mutex    *sync.RWMutex
+	dispatch *sync.Mutex
+	calls    map[string]*asterisk.Call
+}
+
+var callShards = newCallShards()
+
+func newCallShards() []*callShard {
+	shards := make([]*callShard, callShardsCount)
+	for i := range shards {
+		shards[i] = &callShard{
+			mutex:    new(sync.RWMutex),
+			dispatch: new(sync.Mutex),
+			calls:    make(map[string]*asterisk.Call),
+		}
+	}
+	return shards
+}
+
+// shardOf return the shard of a Uniqueid
+func shardOf(uniqueID string) *callShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(uniqueID))
+	return callShards[hash.Sum32()%callShardsCount]
+}
+
+// forEachCall call f for each call in progress, one shard locked at a time
+func forEachCall(f func(*asterisk.Call)) {
+	for _, shard := range callShards {
+		shard.mutex.RLock()
+		for _, call := range shard.calls {
+			f(call)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
+func watch(call *asterisk.Call) {
+	shard := shardOf(call.UniqueID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	shard.calls[call.UniqueID] = call
+}
+
+func unwatch(call *asterisk.Call) {
+	shard := shardOf(call.UniqueID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(shard.calls, call.UniqueID)
+}
+
+func isWatched(uniqueID string) (*asterisk.Call, bool) {
+	shard := shardOf(uniqueID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	value, found := shard.calls[uniqueID]
+	return value, found
+}